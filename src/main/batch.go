@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"pixl"
+)
+
+// image extensions picked up when a directory is given to -batch
+var imageExts = map[string]bool{".png": true, ".jpg": true, ".jpeg": true, ".gif": true}
+
+// runBatch runs the pipeline headless on every image matched by args, -j at
+// a time. A file that fails is reported and skipped; the error returned
+// only summarizes how many failed.
+func runBatch(args []string) error {
+	if _, err := outputFormat(*nameTmpl); err != nil {
+		return err
+	}
+	files, err := expandInputs(args)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no input images")
+	}
+	if err := checkNames(files); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(*outDir, 0755); err != nil {
+		return err
+	}
+
+	n := *jobs
+	if n < 1 {
+		n = 1
+	}
+	work := make(chan int)
+	errs := make([]error, len(files))
+	var mu sync.Mutex // keeps each file's output together
+	var wg sync.WaitGroup
+	for w := 0; w < n; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				var out bytes.Buffer
+				errs[i] = safeProcessFile(files[i], i, &out)
+				mu.Lock()
+				os.Stdout.Write(out.Bytes())
+				if errs[i] != nil {
+					fmt.Fprintf(messages(os.Stdout), "FAILED: %v\n", errs[i])
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for i := range files {
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+
+	failed := 0
+	for _, err := range errs {
+		if err != nil {
+			failed++
+		}
+	}
+	fmt.Fprintf(messages(os.Stdout), "%d of %d images processed\n", len(files)-failed, len(files))
+	if failed > 0 {
+		return fmt.Errorf("%d images failed", failed)
+	}
+	return nil
+}
+
+// processFile is one headless run. Each file gets its own seed, offset from
+// -seed by its position in the sorted input list, so a batch is
+// reproducible as a whole.
+func processFile(name string, i int, out *bytes.Buffer) error {
+	fileSeed := *seed + int64(i)
+	fmt.Fprintf(messages(out), "%s: seed %d\n", name, fileSeed)
+	s, err := newSession(name, fileSeed, out)
+	if err != nil {
+		return err
+	}
+	expand := func(tmpl string) string {
+		if tmpl == "" {
+			return ""
+		}
+		return filepath.Join(*outDir, expandName(tmpl, name, s.pix, fileSeed))
+	}
+	return saveAs(s, outputs{expand(*nameTmpl), expand(*permOut), expand(*gifOut), expand(*framesOut)}, out)
+}
+
+// safeProcessFile is processFile with a panic turned into an error, so one
+// bad file can't take the rest of the batch down with it.
+func safeProcessFile(name string, i int, out *bytes.Buffer) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%s: panic: %v", name, r)
+		}
+	}()
+	return processFile(name, i, out)
+}
+
+// checkNames fails if two inputs would be written to the same files, such
+// as a.png and a.jpg under the default template. Only the fields that come
+// from the input file and its seed are told apart, since the tile counts
+// aren't known until an image is loaded.
+func checkNames(files []string) error {
+	for _, tmpl := range []string{*nameTmpl, *permOut, *gifOut, *framesOut} {
+		if tmpl == "" {
+			continue
+		}
+		seen := make(map[string]string)
+		for i, f := range files {
+			out := expandName(tmpl, f, new(pixl.Pixl), *seed+int64(i))
+			if prev, ok := seen[out]; ok {
+				return fmt.Errorf("%s and %s would both be written to the same file by %q; add {ext} to the template", prev, f, tmpl)
+			}
+			seen[out] = f
+		}
+	}
+	return nil
+}
+
+// expandName fills in a template such as "{name}_b{blocksize}.png". The
+// fields are {name} and {ext} of the input file, {blocksize} (W or WxH),
+// {cols}, {rows} and {seed}.
+func expandName(tmpl, input string, pix *pixl.Pixl, seed int64) string {
+	base := filepath.Base(input)
+	ext := filepath.Ext(base)
+	bs := fmt.Sprint(pix.BlockW)
+	if pix.BlockH != pix.BlockW {
+		bs = fmt.Sprintf("%dx%d", pix.BlockW, pix.BlockH)
+	}
+	return strings.NewReplacer(
+		"{name}", strings.TrimSuffix(base, ext),
+		"{ext}", strings.TrimPrefix(ext, "."),
+		"{blocksize}", bs,
+		"{cols}", fmt.Sprint(pix.NumCols),
+		"{rows}", fmt.Sprint(pix.NumRows),
+		"{seed}", fmt.Sprint(seed),
+	).Replace(tmpl)
+}
+
+// expandInputs turns directories and glob patterns into a sorted list of
+// files without duplicates.
+func expandInputs(args []string) ([]string, error) {
+	seen := make(map[string]bool)
+	var files []string
+	add := func(f string) {
+		if !seen[f] {
+			seen[f] = true
+			files = append(files, f)
+		}
+	}
+	for _, arg := range args {
+		if fi, err := os.Stat(arg); err == nil && fi.IsDir() {
+			entries, err := os.ReadDir(arg)
+			if err != nil {
+				return nil, err
+			}
+			for _, e := range entries {
+				if !e.IsDir() && imageExts[strings.ToLower(filepath.Ext(e.Name()))] {
+					add(filepath.Join(arg, e.Name()))
+				}
+			}
+			continue
+		}
+		matches, err := filepath.Glob(arg)
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("%s: no such file", arg)
+		}
+		for _, m := range matches {
+			add(m)
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
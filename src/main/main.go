@@ -3,17 +3,20 @@ package main
 import (
 	"fmt"
 	"pixl"
+	"pixl/colordist"
+	"pixl/mosaic"
+	"pixl/quant"
 	"time"
-	"math"
-	"math/rand"
 	"flag"
+	"strings"
 	"os"
+	"runtime"
 	"bufio"
+	"io"
 	"image"
 	"image/color"
+	"path/filepath"
 
-	"x-go-binding/ui"
-	"x-go-binding/ui/x11"
 
 	_ "image/gif"
 	_ "image/jpeg"
@@ -21,103 +24,475 @@ import (
 )
 
 var input = flag.String("i", "", "input file")
-var output = flag.String("o", "out.png", "output file")
+var output = flag.String("o", "out.png", "output file; its extension picks the format unless -format is given")
+var format = flag.String("format", "", "output format: png, jpeg, gif, ppm, pam or svg")
+var quality = flag.Int("quality", 90, "JPEG quality, 1 to 100")
 var shuffle   = flag.Bool("s", false, "shuffle the pixels?")
-var blocksize = flag.Int("b", 10, "blocksize")
+var strategy = flag.String("shuffle", "", "shuffle with a strategy: all, local:R, rows, cols, luma:LO-HI or partial:FRAC")
+var blocksize = flag.Int("b", 10, "number of columns of square blocks")
+var gridSize = flag.String("grid", "", "grid as COLSxROWS, e.g. 40x30 (overrides -b)")
+var blockSize = flag.String("block", "", "block size in pixels as WxH or W (overrides -b and -grid)")
+var remainder = flag.String("remainder", "crop", "what to do with leftover edge pixels: crop, pad or stretch")
+var tiles = flag.String("tiles", "square", "tile shape: square, hex, hex-axial, tri, or superpixel cells voronoi[:JITTER] or slic[:COMPACTNESS]")
+var maskFile = flag.String("mask", "", "pixelate only the tiles touching a mask: a PNG, using its alpha channel, or a JSON list of regions")
+var feather = flag.Int("feather", 0, "fade masked pixelation into the source over this many pixels")
 var iters = flag.Int("iters", 0, "number of iterations of clustering algorithm to perform")
 var freq = flag.Float64("f", .1, "Fraction of tiles to swap on each iteration of algo")
+var aggregate = flag.String("a", "mean", "aggregate function: random, mean, median, mode, center, darkest or brightest")
+var metric = flag.String("d", "ycbcr", "color distance for clustering: rgb, redmean, ycbcr, cie76, cie94 or ciede2000")
+var numColors = flag.Int("colors", 0, "reduce tiles to this many colors")
+var quantizer = flag.String("quant", "mediancut", "how -colors picks colors: mediancut or kmeans")
+var paletteName = flag.String("palette", "", "map tiles to a fixed palette: pico8, gameboy, cga, or a GIMP .gpl or hex list file")
+var dither = flag.String("dither", "", "dither tiles to the -palette or -colors palette: floyd-steinberg, atkinson, sierra or bayer[:N]")
+var mosaicDir = flag.String("mosaic", "", "draw each tile as the best-matching image from this directory")
+var mosaicIndex = flag.String("mosaic-index", "", "where to keep the -mosaic library index (default DIR/.pixl-index.json)")
+var reuse = flag.Int("reuse", 0, "how many tiles may use the same -mosaic image; 0 means no limit")
+var blendFrac = flag.Float64("blend", 0, "tint -mosaic images toward their tile's color by this fraction, 0 to 1")
+var anneal = flag.String("anneal", "", "cluster by simulated annealing with this schedule: linear, exponential or adaptive")
+var t0 = flag.Float64("t0", 0, "starting temperature for -anneal; estimated when 0")
+var radius = flag.Int("radius", 0, "maximum distance in tiles between swapped tiles for -anneal; 0 means anywhere")
+var sortBy = flag.String("sort", "", "pixel-sort tiles as PATH:KEY; paths are rows, cols, diagonals and hilbert, keys are hue, luma, saturation, red, green and blue")
+var sortLo = flag.Float64("sort-lo", 0, "only sort runs of tiles whose key is at least this")
+var sortHi = flag.Float64("sort-hi", 1, "only sort runs of tiles whose key is at most this")
+var permOut = flag.String("perm", "", "also write the tile permutation as JSON to this file when saving")
+var unshuffle = flag.String("unshuffle", "", "undo the tile permutation in this JSON file after pixelating")
+var history = flag.Int("history", 100, "number of iterations the viewer can undo; 0 disables undo, negative is unlimited")
+var workers = flag.Int("workers", 0, "run clustering steps on this many goroutines; results depend only on -seed, not on the count")
+var stats = flag.String("stats", "", "print per-iteration energy statistics as text or json")
+var headless = flag.Bool("headless", false, "write -o and exit instead of opening a window; no X display needed")
+var record = flag.Int("record", 0, "record a frame of the clustering every this many iterations, for -gif and -frames")
+var gifOut = flag.String("gif", "", "write the recorded frames to this animated GIF when saving")
+var framesOut = flag.String("frames", "", "write the recorded frames as PNGs named by this pattern, e.g. frames/%04d.png, when saving")
+var delay = flag.Int("delay", 10, "delay between GIF frames in hundredths of a second")
+var batch = flag.Bool("batch", false, "process every image in the directories or glob patterns given as arguments; implies -headless")
+var jobs = flag.Int("j", runtime.NumCPU(), "number of images -batch processes at once")
+var nameTmpl = flag.String("name", "{name}_b{blocksize}.png", "output file name template for -batch; also applies to -perm, -gif and -frames")
+var outDir = flag.String("outdir", ".", "directory -batch writes into")
+var seed = flag.Int64("seed", 0, "random seed; picked from the clock when not given")
 
-func random (bl image.Point, p *pixl.Pixl) color.Color {
-	subImg := p.Image.SubImage(p.GetBlock(bl))
-	bounds := subImg.Bounds()
-	offsetX := rand.Int() % p.BlockSize
-	offsetY := rand.Int() % p.BlockSize
-	return p.Image.At(bounds.Min.X + offsetX, bounds.Min.Y + offsetY)
+func unbiased (p pixl.Pixeler, p1, p2 image.Point) bool {
+	return true
 }
 
-func unbiased (p *pixl.Pixl, p1, p2 image.Point) bool {
-	return true
+// gridSpec builds the grid description from the -b, -grid, -block,
+// -remainder, -tiles, -mask and -feather flags.
+func gridSpec() (pixl.Spec, error) {
+	var s pixl.Spec
+	var err error
+	if s.Remainder, err = pixl.ParseRemainder(*remainder); err != nil {
+		return s, err
+	}
+	if s.Topology, err = pixl.LookupTopology(*tiles); err != nil {
+		return s, err
+	}
+	if *maskFile != "" {
+		if s.Mask, err = loadMask(*maskFile); err != nil {
+			return s, err
+		}
+		s.Feather = *feather
+	}
+	switch {
+	case *blockSize != "":
+		s.BlockW, s.BlockH, err = parseSize(*blockSize)
+		if _, sq := s.Topology.(pixl.Square); !sq && !strings.Contains(*blockSize, "x") {
+			// a single size is the width of a regular tile
+			s.BlockH = 0
+		}
+	case *gridSize != "":
+		s.Cols, s.Rows, err = parseSize(*gridSize)
+	default:
+		s.Cols = *blocksize
+	}
+	return s, err
 }
 
-func euclid(c1 color.Color, c2 color.Color) float64 {
-	r1, g1, b1, _ := c1.RGBA()
-	r2, g2, b2, _ := c2.RGBA()
+// loadMask reads a JSON region list, or the alpha channel of any other
+// image.
+func loadMask(name string) (pixl.Mask, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if strings.EqualFold(filepath.Ext(name), ".json") {
+		return pixl.ReadRegions(f)
+	}
+	img, _, err := image.Decode(bufio.NewReader(f))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return pixl.AlphaMask{Image: img}, nil
+}
 
-	// convert to YUI
-	y1, cb1, cr1 := color.RGBToYCbCr(uint8(r1), uint8(g1), uint8(b1))
-	y2, cb2, cr2 := color.RGBToYCbCr(uint8(r2), uint8(g2), uint8(b2))
+// parseSize parses "AxB" or a single "A", which means "AxA".
+func parseSize(str string) (int, int, error) {
+	var a, b int
+	if n, _ := fmt.Sscanf(str, "%dx%d", &a, &b); n == 2 && a > 0 && b > 0 {
+		return a, b, nil
+	}
+	if n, err := fmt.Sscanf(str, "%d", &a); n == 1 && err == nil && a > 0 && !strings.Contains(str, "x") {
+		return a, a, nil
+	}
+	return 0, 0, fmt.Errorf("bad size %q, want AxB", str)
+}
 
-	score := math.Sqrt(math.Pow(float64(y1-y2),2) + math.Pow(float64(cb1-cb2),2) + math.Pow(float64(cr1-cr2),2))
-	return score
+// sortTiles applies the -sort, -sort-lo and -sort-hi flags to pix.
+func sortTiles(pix *pixl.Pixl, spec string) error {
+	parts := strings.SplitN(spec, ":", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("bad -sort %q, want PATH:KEY", spec)
+	}
+	path, err := pixl.LookupPath(parts[0])
+	if err != nil {
+		return err
+	}
+	key, err := pixl.LookupKey(parts[1])
+	if err != nil {
+		return err
+	}
+	pixl.SortTiles(pix, path(pix.Dims()), key, *sortLo, *sortHi)
+	return nil
 }
 
-func main () {
+// outputs names the files a session is saved to; empty names are skipped.
+type outputs struct {
+	image, perm string
+	// animation of the clustering, if recording
+	gif, frames string
+}
+
+// save writes the session to the files named by -o, -perm, -gif and -frames.
+func save(s *session) error {
+	return saveAs(s, outputs{*output, *permOut, *gifOut, *framesOut}, os.Stdout)
+}
+
+// saveAs writes the session to the files in o and says so on out.
+func saveAs(s *session, o outputs, out io.Writer) error {
+	out = messages(out)
+	pix := s.pix
+	format, err := outputFormat(o.image)
+	if err != nil {
+		return err
+	}
+	err = writeFile(o.image, func(w io.Writer) error {
+		return pix.EncodeAs(w, format, pixl.EncodeOptions{Quality: *quality})
+	})
+	if err != nil {
+		return err
+	}
+	if o.perm != "" {
+		if err := writeFile(o.perm, pix.WritePerm); err != nil {
+			return err
+		}
+	}
+	if s.rec != nil && o.gif != "" {
+		err := writeFile(o.gif, func(w io.Writer) error {
+			return s.rec.WriteGIF(w, pix)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if s.rec != nil && o.frames != "" {
+		if err := s.rec.WritePNGs(o.frames, pix); err != nil {
+			return err
+		}
+	}
+	d := pix.Displacement()
+	fmt.Fprintf(out, "saved %s; %d tiles moved, mean displacement %.2f, max %.2f\n", o.image, d.Moved, d.Mean, d.Max)
+	if s.rec != nil {
+		fmt.Fprintf(out, "recorded %d frames\n", s.rec.Len())
+	}
+	if s.mos != nil && s.mos.Err != nil {
+		fmt.Fprintf(out, "some mosaic tiles drawn flat: %v\n", s.mos.Err)
+	}
+	return nil
+}
+
+// quantPalette returns the palette chosen by -palette or computed from the
+// tiles of pix by -colors and -quant, or nil if neither flag was given.
+func quantPalette(pix *pixl.Pixl) (color.Palette, error) {
+	switch {
+	case *paletteName != "":
+		return quant.Lookup(*paletteName)
+	case *numColors > 0 && *quantizer == "mediancut":
+		return quant.MedianCut(pix.Tiles, *numColors), nil
+	case *numColors > 0 && *quantizer == "kmeans":
+		return quant.KMeans(pix.Tiles, *numColors, pix.Rand), nil
+	case *numColors > 0:
+		return nil, fmt.Errorf("unknown -quant %q", *quantizer)
+	}
+	return nil, nil
+}
+
+// outputFormat is -format if given, otherwise the format matching the
+// extension of name.
+func outputFormat(name string) (string, error) {
+	if *format == "" {
+		return pixl.FormatFromName(name)
+	}
+	for _, f := range pixl.Formats {
+		if f == *format {
+			return f, nil
+		}
+	}
+	return "", fmt.Errorf("unknown -format %q; want one of %s", *format, strings.Join(pixl.Formats, ", "))
+}
+
+func writeFile(name string, encode func(io.Writer) error) error {
+	outf, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	defer outf.Close()
+	writer := bufio.NewWriter(outf)
+	if err := encode(writer); err != nil {
+		return err
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	return outf.Close()
+}
+
+// undoPerm reads a permutation saved with -perm and undoes it.
+func undoPerm(pix *pixl.Pixl, name string) error {
+	// slic cells follow the image, so the shuffled image is cut differently
+	// from the one the permutation was made on
+	if _, ok := pix.Topology.(*pixl.SLIC); ok {
+		return fmt.Errorf("-unshuffle doesn't work with slic tiles")
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	perm, err := pix.ReadPerm(f)
+	if err != nil {
+		return err
+	}
+	return pix.Unpermute(perm)
+}
+
+// flagSet reports whether the named flag was given on the command line.
+func flagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
 
-	rand.Seed(time.Now().UnixNano())
+func main () {
 
 	flag.Parse()
 
+	if !flagSet("seed") {
+		*seed = time.Now().UnixNano()
+	}
+	fmt.Fprintln(messages(os.Stdout), "seed:", *seed)
+
+	if *batch {
+		if err := runBatch(flag.Args()); err != nil {
+			fmt.Fprintln(messages(os.Stdout), err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	if *input == "" {
-		fmt.Println("No input image!")
+		fmt.Fprintln(messages(os.Stdout), "No input image!")
+		os.Exit(1)
 	}
 
-	pix := new(pixl.Pixl)
+	// fail before doing any work if the output can't be written
+	if _, err := outputFormat(*output); err != nil {
+		fmt.Fprintln(messages(os.Stdout), err)
+		os.Exit(1)
+	}
 
-	inf, err := os.Open(*input)
-	defer inf.Close()
-	if err == nil {
-		reader := bufio.NewReader(inf)
-		if pix.Decode(reader) != nil {
-			fmt.Println("file open failed")
+	s, err := newSession(*input, *seed, os.Stdout)
+	if err != nil {
+		fmt.Fprintln(messages(os.Stdout), err)
+		os.Exit(1)
+	}
+
+	if *headless {
+		if err := save(s); err != nil {
+			fmt.Fprintln(messages(os.Stdout), err)
 			os.Exit(1)
 		}
+		return
+	}
+
+	if err := view(s); err != nil {
+		fmt.Fprintln(messages(os.Stdout), err)
+		os.Exit(1)
+	}
+}
+
+// A session is one image taken through the pipeline: pixelated, rearranged
+// and clustered according to the flags.
+type session struct {
+	pix  *pixl.Pixl
+	hist *pixl.History
+	// nil unless -record is given
+	rec *pixl.Recorder
+	// nil unless -mosaic is given
+	mos *mosaic.Mosaic
+	// runs one more clustering iteration
+	iterate func()
+}
+
+// newSession loads input and runs everything up to and including the -iters
+// clustering iterations, printing progress to out.
+func newSession(input string, seed int64, out io.Writer) (*session, error) {
+	dist, err := colordist.Lookup(*metric)
+	if err != nil {
+		return nil, err
+	}
+	dist = pixl.NewDistCache(dist, 0).Dist
+
+	pix := new(pixl.Pixl)
+	pix.Seed(seed)
+	// enough to regenerate the output bit-for-bit
+	pix.Meta = map[string]string{
+		"pixl-seed": fmt.Sprint(seed),
+		"pixl-args": strings.Join(os.Args[1:], " "),
+	}
+
+	agg, err := pixl.LookupAggregate(*aggregate, pix.Rand)
+	if err != nil {
+		return nil, err
+	}
+
+	inf, err := os.Open(input)
+	if err != nil {
+		return nil, err
+	}
+	defer inf.Close()
+	if err := pix.Decode(bufio.NewReader(inf)); err != nil {
+		return nil, fmt.Errorf("%s: %v", input, err)
+	}
+
+	spec, err := gridSpec()
+	if err != nil {
+		return nil, err
 	}
 
-	// resize the window to fit the picture
-	bounds := pix.Image.Bounds()
+	if err := pix.Pixelate(spec, agg); err != nil {
+		return nil, err
+	}
 
-	w, err := x11.NewWindow(bounds.Dx(), bounds.Dy())
+	pal, err := quantPalette(pix)
 	if err != nil {
-			fmt.Println(err)
-			return
+		return nil, err
+	}
+	switch {
+	case *dither != "" && pal == nil:
+		return nil, fmt.Errorf("-dither needs -palette or -colors")
+	case *dither != "":
+		f, err := pixl.LookupDither(*dither)
+		if err != nil {
+			return nil, err
+		}
+		pix.Dither(pal, dist, f)
+	case pal != nil:
+		pix.Quantize(pal, dist)
+	}
+
+	var mos *mosaic.Mosaic
+	if *mosaicDir != "" {
+		idx, err := library()
+		if err != nil {
+			return nil, err
+		}
+		mos = mosaic.New(idx, mosaic.Options{MaxReuse: *reuse, Blend: *blendFrac, Dist: dist})
+		pix.Paint = mos.Paint
 	}
-	pix.Window = w
 
-	pix.Pixelate(*blocksize, random)
+	if *unshuffle != "" {
+		if err := undoPerm(pix, *unshuffle); err != nil {
+			return nil, err
+		}
+	}
 
 	if *shuffle {
 		pix.Shuffle(unbiased)
 	}
+	if *strategy != "" {
+		s, err := pixl.LookupShuffle(*strategy)
+		if err != nil {
+			return nil, err
+		}
+		pix.ShuffleWith(s)
+	}
 
-	// run the clustering algo iters times
-	if *iters != 0 {
-		for i:=0; i < *iters; i++ {
-			pix.DoStep(*freq, euclid)
-			fmt.Println(i)
+	if *sortBy != "" {
+		if err := sortTiles(pix, *sortBy); err != nil {
+			return nil, err
 		}
 	}
 
-	pix.WriteToScreen()
+	// same number of proposals per iteration for both algorithms
+	perIter := int(float64(len(pix.Tiles)) * *freq)
+	step := func() int {
+		if *workers > 0 {
+			return pix.ParallelStep(*freq, dist, *workers)
+		}
+		return pix.DoStep(*freq, dist)
+	}
+	if *anneal != "" {
+		sched, err := pixl.LookupSchedule(*anneal)
+		if err != nil {
+			return nil, err
+		}
+		a := &pixl.Annealer{
+			Dist:     dist,
+			Schedule: sched,
+			T0:       *t0,
+			Steps:    perIter * max(*iters, 1),
+			Radius:   *radius,
+			Rand:     pix.Rand,
+		}
+		step = func() int {
+			return a.Anneal(pix, perIter)
+		}
+	}
 
-	for e := range w.EventChan() {
-		switch e := e.(type) {
-		case ui.KeyEvent:
-			if e.Key == ' ' { // perform another iteration
-				pix.DoStep(*freq, euclid)
-				pix.WriteToScreen()
-			} else if e.Key == 's' { // save image
-				outf, err := os.Create(*output)
-				defer outf.Close()
-				if err == nil {
-					writer := bufio.NewWriter(outf)
-					pix.Encode(writer)
-				} else {
-					fmt.Println(err)
-					os.Exit(1)
-				}
-			}
+	report, err := newReporter(*stats, out, pix, dist)
+	if err != nil {
+		return nil, err
+	}
+
+	// only the viewer can undo, so headless runs just count iterations
+	limit := *history
+	if *headless || *batch {
+		limit = 0
+	}
+	hist := pixl.NewHistory(limit)
+	s := &session{pix: pix, hist: hist, mos: mos}
+	if *record > 0 {
+		s.rec = pixl.NewRecorder(*record)
+		s.rec.Delay = *delay
+		s.rec.Frame(pix)
+	}
+	s.iterate = func() {
+		hist.Begin(pix)
+		moved := step()
+		hist.Commit(pix)
+		// numbered as in the history, from 1
+		report(hist.Iter(), perIter, moved)
+		if s.rec != nil {
+			s.rec.Capture(pix, hist.Iter())
 		}
 	}
 
+	// run the clustering algo iters times
+	for i:=0; i < *iters; i++ {
+		s.iterate()
+	}
+	return s, nil
 }
-
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"pixl/mosaic"
+)
+
+var (
+	libOnce sync.Once
+	lib     *mosaic.Index
+	libErr  error
+)
+
+// library returns the index of the -mosaic directory, updating the on-disk
+// index first. It is built once and shared by every session.
+func library() (*mosaic.Index, error) {
+	libOnce.Do(func() {
+		name := *mosaicIndex
+		if name == "" {
+			name = filepath.Join(*mosaicDir, ".pixl-index.json")
+		}
+		old, err := mosaic.LoadIndex(name)
+		if err != nil && !os.IsNotExist(err) {
+			fmt.Fprintf(messages(os.Stdout), "ignoring mosaic index %s: %v\n", name, err)
+		}
+		var skipped []string
+		lib, skipped, libErr = mosaic.BuildIndex(*mosaicDir, old)
+		if libErr != nil {
+			return
+		}
+		for _, s := range skipped {
+			fmt.Fprintln(messages(os.Stdout), "mosaic: skipping", s)
+		}
+		fmt.Fprintf(messages(os.Stdout), "mosaic: %d library images\n", len(lib.Entries))
+		if len(lib.Entries) == 0 {
+			libErr = fmt.Errorf("no images in %s", *mosaicDir)
+			return
+		}
+		// the index on disk only saves rescanning next time
+		if err := lib.Save(name); err != nil {
+			fmt.Fprintf(messages(os.Stdout), "not saving mosaic index: %v\n", err)
+		}
+	})
+	return lib, libErr
+}
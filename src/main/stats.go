@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image/color"
+	"io"
+	"os"
+	"pixl"
+)
+
+// messages is where progress and other human-readable output for out goes:
+// out itself, except that -stats json keeps out for JSON lines and sends
+// messages to stderr.
+func messages(out io.Writer) io.Writer {
+	if *stats == "json" {
+		return os.Stderr
+	}
+	return out
+}
+
+// newReporter returns a function to call after each clustering iteration
+// with the number of swaps proposed and accepted. It prints the iteration
+// number alone when format is empty, and full pixl.Stats when it is "text"
+// or "json" (one object per line).
+func newReporter(format string, w io.Writer, pix *pixl.Pixl, dist func(c1, c2 color.Color) float64) (func(iter, proposed, accepted int), error) {
+	if format == "" {
+		return func(iter, proposed, accepted int) {
+			fmt.Fprintln(w, iter)
+		}, nil
+	}
+	if format != "text" && format != "json" {
+		return nil, fmt.Errorf("unknown stats format %q", format)
+	}
+	enc := json.NewEncoder(w)
+	last := pixl.Energy(pix, dist)
+	return func(iter, proposed, accepted int) {
+		e := pixl.Energy(pix, dist)
+		st := pixl.Stats{
+			Iter:     iter,
+			Energy:   e,
+			Delta:    e - last,
+			Proposed: proposed,
+			Accepted: accepted,
+		}
+		last = e
+		if format == "json" {
+			enc.Encode(st)
+		} else {
+			fmt.Fprintf(w, "iter %d energy %.2f delta %.2f proposed %d accepted %d\n",
+				st.Iter, st.Energy, st.Delta, st.Proposed, st.Accepted)
+		}
+	}, nil
+}
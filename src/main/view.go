@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"os"
+
+	"pixl"
+
+	"x-go-binding/ui"
+	"x-go-binding/ui/x11"
+)
+
+// view shows the session in an X window until it is closed.
+//
+// Keys: space runs another iteration, u and r undo and redo one, digits
+// followed by g jump to that iteration, and s saves.
+func view(s *session) error {
+	pix := s.pix
+
+	// resize the window to fit the picture
+	bounds := pix.Render().Bounds()
+
+	w, err := x11.NewWindow(bounds.Dx(), bounds.Dy())
+	if err != nil {
+		return fmt.Errorf("%v (use -headless to run without a display)", err)
+	}
+
+	writeToScreen(w, pix)
+
+	// digits typed before 'g'
+	jumpTo := ""
+	for e := range w.EventChan() {
+		switch e := e.(type) {
+		case ui.KeyEvent:
+			if e.Key >= '0' && e.Key <= '9' {
+				jumpTo += string(rune(e.Key))
+			} else if e.Key == 'g' { // jump to the typed iteration
+				var n int
+				fmt.Sscan(jumpTo, &n)
+				jumpTo = ""
+				if err := s.hist.Jump(pix, n); err != nil {
+					fmt.Fprintln(messages(os.Stdout), err)
+				}
+				fmt.Fprintln(messages(os.Stdout), "at iteration", s.hist.Iter())
+				writeToScreen(w, pix)
+			} else if e.Key == 'u' { // undo an iteration
+				if s.hist.Undo(pix) {
+					fmt.Fprintln(messages(os.Stdout), "at iteration", s.hist.Iter())
+					writeToScreen(w, pix)
+				}
+			} else if e.Key == 'r' { // redo an iteration
+				if s.hist.Redo(pix) {
+					fmt.Fprintln(messages(os.Stdout), "at iteration", s.hist.Iter())
+					writeToScreen(w, pix)
+				}
+			} else if e.Key == ' ' { // perform another iteration
+				s.iterate()
+				writeToScreen(w, pix)
+			} else if e.Key == 's' { // save image
+				if err := save(s); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func writeToScreen(w ui.Window, pix *pixl.Pixl) {
+	draw.Draw(w.Screen(), w.Screen().Bounds(), pix.Render(), image.ZP, draw.Src)
+	w.FlushImage()
+}
@@ -0,0 +1,180 @@
+package pixl
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// Aggregates maps the names accepted by LookupAggregate to their functions.
+// The "random" aggregate needs a random source and is built by
+// LookupAggregate itself.
+var Aggregates = map[string]AggregateFunc{
+	"mean":      Mean,
+	"median":    Median,
+	"mode":      Mode,
+	"center":    Center,
+	"darkest":   Darkest,
+	"brightest": Brightest,
+}
+
+func LookupAggregate(name string, rnd *rand.Rand) (AggregateFunc, error) {
+	if name == "random" {
+		return Random(rnd), nil
+	}
+	f, ok := Aggregates[name]
+	if !ok {
+		return nil, fmt.Errorf("pixl: unknown aggregate function %q", name)
+	}
+	return f, nil
+}
+
+// Mean averages each channel over the block.
+func Mean(img image.Image, r image.Rectangle) color.Color {
+	var sr, sg, sb, sa, n uint64
+	pixels(img, r, func(x, y int) {
+		cr, cg, cb, ca := img.At(x, y).RGBA()
+		sr += uint64(cr)
+		sg += uint64(cg)
+		sb += uint64(cb)
+		sa += uint64(ca)
+		n++
+	})
+	if n == 0 {
+		return color.Transparent
+	}
+	return color.RGBA64{uint16(sr / n), uint16(sg / n), uint16(sb / n), uint16(sa / n)}
+}
+
+// Median takes the median of each channel independently.
+func Median(img image.Image, r image.Rectangle) color.Color {
+	var ch [4][]int
+	pixels(img, r, func(x, y int) {
+		cr, cg, cb, ca := img.At(x, y).RGBA()
+		ch[0] = append(ch[0], int(cr))
+		ch[1] = append(ch[1], int(cg))
+		ch[2] = append(ch[2], int(cb))
+		ch[3] = append(ch[3], int(ca))
+	})
+	n := len(ch[0])
+	if n == 0 {
+		return color.Transparent
+	}
+	var m [4]uint16
+	for i := range ch {
+		sort.Ints(ch[i])
+		m[i] = uint16(ch[i][n/2])
+	}
+	return color.RGBA64{m[0], m[1], m[2], m[3]}
+}
+
+// number of bits per channel kept when binning colors for Mode
+const modeBits = 4
+
+// Mode bins colors into a coarse 3D histogram and returns the mean of the
+// most populated bin.
+func Mode(img image.Image, r image.Rectangle) color.Color {
+	type bin struct {
+		n          int
+		r, g, b, a uint64
+	}
+	bins := make(map[uint32]*bin)
+	var best *bin
+	pixels(img, r, func(x, y int) {
+		cr, cg, cb, ca := img.At(x, y).RGBA()
+		shift := 16 - modeBits
+		key := cr>>shift<<(2*modeBits) | cg>>shift<<modeBits | cb>>shift
+		bn := bins[key]
+		if bn == nil {
+			bn = new(bin)
+			bins[key] = bn
+		}
+		bn.n++
+		bn.r += uint64(cr)
+		bn.g += uint64(cg)
+		bn.b += uint64(cb)
+		bn.a += uint64(ca)
+		// ties go to the bin seen first so results don't depend on map order
+		if best == nil || bn.n > best.n {
+			best = bn
+		}
+	})
+	if best == nil {
+		return color.Transparent
+	}
+	n := uint64(best.n)
+	return color.RGBA64{uint16(best.r / n), uint16(best.g / n), uint16(best.b / n), uint16(best.a / n)}
+}
+
+// Center is a mean weighted by a Gaussian centered on the block, so the
+// middle of a block counts more than its edges.
+func Center(img image.Image, r image.Rectangle) color.Color {
+	cx := float64(r.Min.X+r.Max.X-1) / 2
+	cy := float64(r.Min.Y+r.Max.Y-1) / 2
+	// sigma of a third of the block puts the edges at about 1.5 sigma
+	sigma := math.Max(float64(r.Dx()), float64(r.Dy())) / 3
+	if sigma <= 0 {
+		return color.Transparent
+	}
+	var sr, sg, sb, sa, sw float64
+	pixels(img, r, func(x, y int) {
+		dx, dy := float64(x)-cx, float64(y)-cy
+		w := math.Exp(-(dx*dx + dy*dy) / (2 * sigma * sigma))
+		cr, cg, cb, ca := img.At(x, y).RGBA()
+		sr += w * float64(cr)
+		sg += w * float64(cg)
+		sb += w * float64(cb)
+		sa += w * float64(ca)
+		sw += w
+	})
+	if sw == 0 {
+		return color.Transparent
+	}
+	return color.RGBA64{uint16(sr / sw), uint16(sg / sw), uint16(sb / sw), uint16(sa / sw)}
+}
+
+// Darkest picks the pixel with the lowest luminance.
+func Darkest(img image.Image, r image.Rectangle) color.Color {
+	return extreme(img, r, func(a, b float64) bool { return a < b })
+}
+
+// Brightest picks the pixel with the highest luminance.
+func Brightest(img image.Image, r image.Rectangle) color.Color {
+	return extreme(img, r, func(a, b float64) bool { return a > b })
+}
+
+func extreme(img image.Image, r image.Rectangle, better func(a, b float64) bool) color.Color {
+	var best color.Color = color.Transparent
+	bestL, found := 0.0, false
+	pixels(img, r, func(x, y int) {
+		c := img.At(x, y)
+		if l := Luma(c); !found || better(l, bestL) {
+			found = true
+			best, bestL = c, l
+		}
+	})
+	return best
+}
+
+// pixels calls fn for each pixel of img inside r, row by row, skipping any
+// that a Shaped img doesn't cover.
+func pixels(img image.Image, r image.Rectangle, fn func(x, y int)) {
+	r = r.Intersect(img.Bounds())
+	shaped, _ := img.(Shaped)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			if shaped == nil || shaped.In(x, y) {
+				fn(x, y)
+			}
+		}
+	}
+}
+
+// Luma returns the Rec. 601 luminance of c in [0, 1].
+func Luma(c color.Color) float64 {
+	r, g, b, _ := c.RGBA()
+	return (0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)) / 0xffff
+}
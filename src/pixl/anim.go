@@ -0,0 +1,142 @@
+package pixl
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/png"
+	"io"
+	"os"
+	"strings"
+
+	"pixl/quant"
+)
+
+// Recorder keeps snapshots of the tiles of a Pixl as it is clustered, to be
+// written out as an animated GIF or a sequence of PNGs. Only tile colors are
+// stored, so a frame costs four bytes per tile.
+type Recorder struct {
+	// Capture keeps one frame every Every iterations
+	Every int
+	// Delay between GIF frames in hundredths of a second
+	Delay int
+
+	frames [][]color.RGBA
+}
+
+func NewRecorder(every int) *Recorder {
+	return &Recorder{Every: every, Delay: 10}
+}
+
+// Capture stores a frame if iter is a multiple of Every.
+func (r *Recorder) Capture(p *Pixl, iter int) {
+	if r.Every > 0 && iter%r.Every == 0 {
+		r.Frame(p)
+	}
+}
+
+// Frame stores a frame unconditionally.
+func (r *Recorder) Frame(p *Pixl) {
+	r.frames = append(r.frames, append([]color.RGBA(nil), p.Tiles...))
+}
+
+// Len is the number of frames stored.
+func (r *Recorder) Len() int {
+	return len(r.frames)
+}
+
+// render draws frame i using the grid of p.
+func (r *Recorder) render(p *Pixl, i int) *image.RGBA {
+	q := *p
+	q.Tiles = r.frames[i]
+	return q.Render()
+}
+
+// WriteGIF writes the frames as an animated GIF that loops forever. Each
+// frame gets its own palette of up to 256 colors chosen by median cut.
+func (r *Recorder) WriteGIF(w io.Writer, p *Pixl) error {
+	anim := &gif.GIF{}
+	for i, tiles := range r.frames {
+		anim.Image = append(anim.Image, gifFrame(r.render(p, i), tiles, p.painted()))
+		anim.Delay = append(anim.Delay, r.Delay)
+	}
+	return gif.EncodeAll(w, anim)
+}
+
+// gifFrame reduces img to a paletted image chosen by median cut. Flat tiles
+// have few distinct colors, so the palette comes from tiles and no
+// dithering is needed; painted tiles are sampled from img and dithered.
+func gifFrame(img *image.RGBA, tiles []color.RGBA, painted bool) *image.Paletted {
+	if !painted {
+		pi := image.NewPaletted(img.Bounds(), quant.MedianCut(tiles, 256))
+		draw.Draw(pi, pi.Bounds(), img, img.Bounds().Min, draw.Src)
+		return pi
+	}
+	const maxSamples = 1 << 16
+	b := img.Bounds()
+	step := max(1, b.Dx()*b.Dy()/maxSamples)
+	var samples []color.RGBA
+	for i := 0; i < b.Dx()*b.Dy(); i += step {
+		samples = append(samples, img.RGBAAt(b.Min.X+i%b.Dx(), b.Min.Y+i/b.Dx()))
+	}
+	pi := image.NewPaletted(b, quant.MedianCut(samples, 256))
+	draw.FloydSteinberg.Draw(pi, b, img, b.Min)
+	return pi
+}
+
+// WritePNGs writes each frame to a file named by formatting pattern, e.g.
+// "frames/%04d.png", with the frame number. pattern must hold exactly one
+// integer verb.
+func (r *Recorder) WritePNGs(pattern string, p *Pixl) error {
+	if err := checkPattern(pattern); err != nil {
+		return err
+	}
+	for i := range r.frames {
+		f, err := os.Create(fmt.Sprintf(pattern, i))
+		if err != nil {
+			return err
+		}
+		err = png.Encode(f, r.render(p, i))
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkPattern makes sure pattern formats one integer and nothing else, so
+// every frame gets a name of its own.
+func checkPattern(pattern string) error {
+	verbs := 0
+	for i := 0; i < len(pattern); i++ {
+		if pattern[i] != '%' {
+			continue
+		}
+		i++
+		if i < len(pattern) && pattern[i] == '%' {
+			continue
+		}
+		for i < len(pattern) && strings.IndexByte("+-# 0123456789", pattern[i]) >= 0 {
+			i++
+		}
+		if i == len(pattern) || strings.IndexByte("bdoxX", pattern[i]) < 0 {
+			return fmt.Errorf("pixl: frame name pattern %q must format the frame number with a verb such as %%d", pattern)
+		}
+		verbs++
+	}
+	if verbs != 1 {
+		return fmt.Errorf("pixl: frame name pattern %q must have exactly one %%d for the frame number", pattern)
+	}
+	return nil
+}
+
+// painted reports whether rendered tiles hold more colors than the tiles
+// themselves, from Paint or from blending with the source under a mask.
+func (p *Pixl) painted() bool {
+	return p.Paint != nil || p.Weights != nil
+}
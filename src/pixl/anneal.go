@@ -0,0 +1,194 @@
+package pixl
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"math/rand"
+)
+
+// A Schedule sets the temperature over the course of an annealing run.
+type Schedule interface {
+	// Temp is the temperature at step out of steps, starting from t0.
+	Temp(t0 float64, step, steps int) float64
+	// Observe is told whether each proposal was accepted.
+	Observe(accepted bool)
+}
+
+// Linear cools steadily from t0 to zero.
+type Linear struct{}
+
+func (Linear) Temp(t0 float64, step, steps int) float64 {
+	if step >= steps {
+		return 0
+	}
+	return t0 * (1 - float64(step)/float64(steps))
+}
+
+func (Linear) Observe(bool) {}
+
+// Exponential multiplies the temperature by a constant factor each step,
+// chosen so that it falls to Final times t0 at the end of the run.
+type Exponential struct {
+	Final float64
+}
+
+func (e Exponential) Temp(t0 float64, step, steps int) float64 {
+	final := e.Final
+	if final <= 0 {
+		final = 1e-3
+	}
+	if steps < 1 {
+		return 0
+	}
+	return t0 * math.Pow(final, float64(step)/float64(steps))
+}
+
+func (Exponential) Observe(bool) {}
+
+// Adaptive cools exponentially but heats up or cools down faster to keep
+// the acceptance rate near a target, which starts at Target and falls to
+// zero by the end of the run.
+type Adaptive struct {
+	Exponential
+	// Desired fraction of accepted proposals at the start of the run
+	Target float64
+	// Number of proposals between adjustments
+	Window int
+
+	scale     float64
+	progress  float64
+	seen, acc int
+}
+
+func NewAdaptive() *Adaptive {
+	return &Adaptive{Target: 0.3, Window: 500}
+}
+
+func (a *Adaptive) Temp(t0 float64, step, steps int) float64 {
+	if a.scale == 0 {
+		a.scale = 1
+	}
+	if steps > 0 {
+		a.progress = float64(step) / float64(steps)
+	}
+	return a.scale * a.Exponential.Temp(t0, step, steps)
+}
+
+func (a *Adaptive) Observe(accepted bool) {
+	a.seen++
+	if accepted {
+		a.acc++
+	}
+	if a.seen < a.Window {
+		return
+	}
+	rate := float64(a.acc) / float64(a.seen)
+	if rate > a.Target*(1-a.progress) {
+		a.scale *= 0.9
+	} else {
+		a.scale *= 1.1
+	}
+	a.seen, a.acc = 0, 0
+}
+
+// Schedules maps the names accepted by LookupSchedule to constructors.
+var Schedules = map[string]func() Schedule{
+	"linear":      func() Schedule { return Linear{} },
+	"exponential": func() Schedule { return Exponential{} },
+	"adaptive":    func() Schedule { return NewAdaptive() },
+}
+
+func LookupSchedule(name string) (Schedule, error) {
+	f, ok := Schedules[name]
+	if !ok {
+		return nil, fmt.Errorf("pixl: unknown schedule %q", name)
+	}
+	return f(), nil
+}
+
+// Annealer rearranges tiles by simulated annealing on Energy. Unlike DoStep
+// it proposes swaps between tiles at any distance and sometimes accepts
+// swaps that make things worse, so it can escape local minima.
+type Annealer struct {
+	Dist     func(c1, c2 color.Color) float64
+	Schedule Schedule
+	// Starting temperature; estimated from the image when zero
+	T0 float64
+	// Total number of proposals in the run, which the schedule spans
+	Steps int
+	// Maximum distance in tiles between swapped tiles; zero means anywhere
+	Radius int
+	Rand   *rand.Rand
+
+	step int
+}
+
+// Anneal makes n swap proposals, continuing the schedule where the previous
+// call left off, and returns how many were accepted.
+func (a *Annealer) Anneal(p Pixeler, n int) int {
+	if len(allPoints(p)) < 2 {
+		return 0
+	}
+	if a.T0 == 0 {
+		a.T0 = a.estimateT0(p)
+	}
+	accepted := 0
+	for i := 0; i < n; i++ {
+		t := a.Schedule.Temp(a.T0, a.step, a.Steps)
+		a.step++
+		p1, p2 := a.propose(p)
+		d := SwapDelta(p, p1, p2, a.Dist)
+		ok := d <= 0 || (t > 0 && a.Rand.Float64() < math.Exp(-d/t))
+		if ok {
+			p.Swap(p1, p2)
+			accepted++
+		}
+		a.Schedule.Observe(ok)
+	}
+	return accepted
+}
+
+// propose picks two distinct tiles no more than Radius apart, neither of
+// them blank.
+func (a *Annealer) propose(p Pixeler) (image.Point, image.Point) {
+	cols, rows := p.Dims()
+	p1 := p.GetPoint(a.Rand.Intn(cols * rows))
+	for !isDrawn(p, p1) {
+		p1 = p.GetPoint(a.Rand.Intn(cols * rows))
+	}
+	for {
+		var p2 image.Point
+		if a.Radius > 0 {
+			r := a.Radius
+			p2 = p1.Add(image.Pt(a.Rand.Intn(2*r+1)-r, a.Rand.Intn(2*r+1)-r))
+			if p2.X < 0 || p2.Y < 0 || p2.X >= cols || p2.Y >= rows {
+				continue
+			}
+		} else {
+			p2 = p.GetPoint(a.Rand.Intn(cols * rows))
+		}
+		if p2 != p1 && isDrawn(p, p2) {
+			return p1, p2
+		}
+	}
+}
+
+// estimateT0 picks a starting temperature at which a typical uphill move is
+// accepted with probability about 0.8.
+func (a *Annealer) estimateT0(p Pixeler) float64 {
+	const samples = 200
+	sum, n := 0.0, 0
+	for i := 0; i < samples; i++ {
+		p1, p2 := a.propose(p)
+		if d := SwapDelta(p, p1, p2, a.Dist); d > 0 {
+			sum += d
+			n++
+		}
+	}
+	if n == 0 {
+		return 1
+	}
+	return -(sum / float64(n)) / math.Log(0.8)
+}
@@ -0,0 +1,326 @@
+package pixl
+
+import (
+	"image"
+	"math"
+	"math/rand"
+	"sort"
+
+	"pixl/colordist"
+)
+
+// A Segmenter is a Topology whose tiles are cut to fit a particular image.
+// Pixelate calls Segment with the source image before laying out the grid.
+type Segmenter interface {
+	Topology
+	// Segment divides img into cells, about as many as s would give square
+	// tiles.
+	Segment(img image.Image, s Spec, rnd *rand.Rand)
+}
+
+// Cells is a Topology of irregular cells given by a label map. The grid is a
+// single row with one tile per cell, numbered in the order their top left
+// pixel comes in the image, and two cells are neighbors when they share an
+// edge. Cells have no polygon outline.
+type Cells struct {
+	// Labels[y*Width+x] is the cell covering pixel (x, y)
+	Labels        []int
+	Width, Height int
+	// Adj is the cell adjacency graph: Adj[i] lists the cells next to cell
+	// i, in increasing order
+	Adj [][]int
+
+	boxes []image.Rectangle
+	// nominal cell size
+	size int
+}
+
+// NewCells makes Cells from a map of labels, one per pixel of a w by h
+// image, row by row. Pixels with the same label that aren't connected
+// become separate cells, and cells smaller than minSize pixels are merged
+// into a neighbor.
+func NewCells(labels []int, w, h, minSize int) *Cells {
+	c := &Cells{Labels: make([]int, w*h), Width: w, Height: h}
+	for i := range c.Labels {
+		c.Labels[i] = -1
+	}
+	// flood fill each connected component in turn
+	n := 0
+	var stack, comp []int
+	for start := range labels {
+		if c.Labels[start] >= 0 {
+			continue
+		}
+		// a labelled neighbor to merge into if the component is too small;
+		// the pixels left of and above start are already done
+		merge := -1
+		if x := start % w; x > 0 {
+			merge = c.Labels[start-1]
+		} else if start >= w {
+			merge = c.Labels[start-w]
+		}
+		comp = comp[:0]
+		stack = append(stack[:0], start)
+		c.Labels[start] = n
+		for len(stack) > 0 {
+			i := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			comp = append(comp, i)
+			x, y := i%w, i/w
+			for _, d := range [4]image.Point{{-1, 0}, {1, 0}, {0, -1}, {0, 1}} {
+				nx, ny := x+d.X, y+d.Y
+				if nx < 0 || ny < 0 || nx >= w || ny >= h {
+					continue
+				}
+				j := ny*w + nx
+				if c.Labels[j] < 0 && labels[j] == labels[start] {
+					c.Labels[j] = n
+					stack = append(stack, j)
+				}
+			}
+		}
+		if len(comp) < minSize && merge >= 0 {
+			for _, i := range comp {
+				c.Labels[i] = merge
+			}
+			continue
+		}
+		n++
+	}
+
+	c.boxes = make([]image.Rectangle, n)
+	adj := make([]map[int]bool, n)
+	for i := range adj {
+		adj[i] = make(map[int]bool)
+	}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			l := c.Labels[y*w+x]
+			c.boxes[l] = c.boxes[l].Union(image.Rect(x, y, x+1, y+1))
+			if x+1 < w {
+				if r := c.Labels[y*w+x+1]; r != l {
+					adj[l][r], adj[r][l] = true, true
+				}
+			}
+			if y+1 < h {
+				if b := c.Labels[(y+1)*w+x]; b != l {
+					adj[l][b], adj[b][l] = true, true
+				}
+			}
+		}
+	}
+	c.Adj = make([][]int, n)
+	for i, set := range adj {
+		for j := range set {
+			c.Adj[i] = append(c.Adj[i], j)
+		}
+		sort.Ints(c.Adj[i])
+	}
+	if n > 0 {
+		c.size = int(math.Round(math.Sqrt(float64(w*h) / float64(n))))
+	}
+	return c
+}
+
+// Len is the number of cells.
+func (c *Cells) Len() int {
+	return len(c.Adj)
+}
+
+func (c *Cells) cells() *Cells {
+	return c
+}
+
+func (c *Cells) Layout(g *Grid, s Spec) {
+	g.NumCols, g.NumRows = c.Len(), 1
+	g.BlockW, g.BlockH = c.size, c.size
+}
+
+func (c *Cells) Bounds(g *Grid) image.Rectangle {
+	return image.Rect(0, 0, c.Width, c.Height)
+}
+
+func (c *Cells) TileAt(g *Grid, x, y int) image.Point {
+	if x < 0 || y < 0 || x >= c.Width || y >= c.Height {
+		return image.Pt(-1, -1)
+	}
+	return image.Pt(c.Labels[y*c.Width+x], 0)
+}
+
+func (c *Cells) Block(g *Grid, bl image.Point) image.Rectangle {
+	return c.boxes[bl.X]
+}
+
+func (c *Cells) Neighbors(g *Grid, bl image.Point) []image.Point {
+	ns := make([]image.Point, len(c.Adj[bl.X]))
+	for i, j := range c.Adj[bl.X] {
+		ns[i] = image.Pt(j, 0)
+	}
+	return ns
+}
+
+func (c *Cells) Polygon(g *Grid, bl image.Point) []Vertex {
+	return nil
+}
+
+// cellSize is the side of the square tiles s would give for img.
+func cellSize(img image.Image, s Spec) int {
+	b := img.Bounds()
+	w, h := tileSize(&Grid{Width: b.Dx(), Height: b.Dy()}, s, 1, spacing{1, 0}, spacing{1, 0})
+	return int(math.Round(math.Sqrt(float64(w * h))))
+}
+
+// Voronoi divides the image into the Voronoi cells of seeds scattered
+// over a grid: one seed per square of the grid, placed at random within
+// the middle Jitter of it.
+type Voronoi struct {
+	// From 0, a seed at the center of every square, to 1, anywhere in it
+	Jitter float64
+	Cells
+}
+
+func (v *Voronoi) Segment(img image.Image, s Spec, rnd *rand.Rand) {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	size := float64(cellSize(img, s))
+	nx := int(math.Ceil(float64(w) / size))
+	ny := int(math.Ceil(float64(h) / size))
+	seeds := make([]Vertex, nx*ny)
+	for j := 0; j < ny; j++ {
+		for i := 0; i < nx; i++ {
+			seeds[j*nx+i] = Vertex{
+				(float64(i) + 0.5 + v.Jitter*(rnd.Float64()-0.5)) * size,
+				(float64(j) + 0.5 + v.Jitter*(rnd.Float64()-0.5)) * size,
+			}
+		}
+	}
+	labels := make([]int, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			px, py := float64(x)+0.5, float64(y)+0.5
+			gx, gy := int(px/size), int(py/size)
+			best, bestD := 0, math.Inf(1)
+			// every seed is inside its square, so the nearest is no more
+			// than two squares away
+			for j := gy - 2; j <= gy+2; j++ {
+				for i := gx - 2; i <= gx+2; i++ {
+					if i < 0 || j < 0 || i >= nx || j >= ny {
+						continue
+					}
+					sd := seeds[j*nx+i]
+					if d := (sd.X-px)*(sd.X-px) + (sd.Y-py)*(sd.Y-py); d < bestD {
+						best, bestD = j*nx+i, d
+					}
+				}
+			}
+			labels[y*w+x] = best
+		}
+	}
+	v.Cells = *NewCells(labels, w, h, 0)
+}
+
+// SLIC divides the image into superpixels by simple linear iterative
+// clustering: k-means on color and position, with each center only
+// competing for the pixels near it, so cells follow edges in the image.
+type SLIC struct {
+	// Weight of distance against color difference; higher gives more
+	// regular cells. Around 10 suits most images.
+	Compactness float64
+	// Number of k-means rounds; 10 when zero
+	Iterations int
+	Cells
+}
+
+func (sl *SLIC) Segment(img image.Image, s Spec, rnd *rand.Rand) {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	size := cellSize(img, s)
+	iters := sl.Iterations
+	if iters <= 0 {
+		iters = 10
+	}
+
+	lab := make([][3]float64, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			l, a, bb := colordist.Lab(img.At(b.Min.X+x, b.Min.Y+y))
+			lab[y*w+x] = [3]float64{l, a, bb}
+		}
+	}
+	grad := func(x, y int) float64 {
+		if x < 1 || y < 1 || x >= w-1 || y >= h-1 {
+			return math.Inf(1)
+		}
+		g := 0.0
+		for k := 0; k < 3; k++ {
+			dx := lab[y*w+x+1][k] - lab[y*w+x-1][k]
+			dy := lab[(y+1)*w+x][k] - lab[(y-1)*w+x][k]
+			g += dx*dx + dy*dy
+		}
+		return g
+	}
+
+	type center struct {
+		x, y float64
+		c    [3]float64
+	}
+	var centers []center
+	for y := size / 2; y < h; y += size {
+		for x := size / 2; x < w; x += size {
+			// start on the smoothest pixel nearby, so no center sits on an edge
+			bx, by, bg := x, y, grad(x, y)
+			for dy := -1; dy <= 1; dy++ {
+				for dx := -1; dx <= 1; dx++ {
+					if g := grad(x+dx, y+dy); g < bg {
+						bx, by, bg = x+dx, y+dy, g
+					}
+				}
+			}
+			centers = append(centers, center{float64(bx), float64(by), lab[by*w+bx]})
+		}
+	}
+
+	labels := make([]int, w*h)
+	dists := make([]float64, w*h)
+	m := sl.Compactness / float64(size)
+	for it := 0; it < iters; it++ {
+		for i := range labels {
+			labels[i], dists[i] = -1, math.Inf(1)
+		}
+		for k, c := range centers {
+			x0, x1 := int(c.x)-size, int(c.x)+size
+			y0, y1 := int(c.y)-size, int(c.y)+size
+			for y := max(y0, 0); y <= min(y1, h-1); y++ {
+				for x := max(x0, 0); x <= min(x1, w-1); x++ {
+					p := lab[y*w+x]
+					dc := (p[0]-c.c[0])*(p[0]-c.c[0]) + (p[1]-c.c[1])*(p[1]-c.c[1]) + (p[2]-c.c[2])*(p[2]-c.c[2])
+					ds := (float64(x)-c.x)*(float64(x)-c.x) + (float64(y)-c.y)*(float64(y)-c.y)
+					if d := dc + ds*m*m; d < dists[y*w+x] {
+						dists[y*w+x], labels[y*w+x] = d, k
+					}
+				}
+			}
+		}
+		sums := make([]center, len(centers))
+		counts := make([]int, len(centers))
+		for i, k := range labels {
+			if k < 0 {
+				continue
+			}
+			sums[k].x += float64(i % w)
+			sums[k].y += float64(i / w)
+			for j := range sums[k].c {
+				sums[k].c[j] += lab[i][j]
+			}
+			counts[k]++
+		}
+		for k, n := range counts {
+			if n == 0 {
+				continue
+			}
+			f := 1 / float64(n)
+			centers[k] = center{sums[k].x * f, sums[k].y * f, [3]float64{sums[k].c[0] * f, sums[k].c[1] * f, sums[k].c[2] * f}}
+		}
+	}
+	sl.Cells = *NewCells(labels, w, h, size*size/4)
+}
@@ -0,0 +1,194 @@
+// Package colordist implements distance metrics between colors, for use as
+// the dist argument of pixl.Pixl.DoStep.
+package colordist
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+)
+
+// Func measures how different two colors look. Larger means more different.
+type Func func(c1, c2 color.Color) float64
+
+// Metrics maps the names accepted by Lookup to their functions.
+var Metrics = map[string]Func{
+	"rgb":       RGB,
+	"redmean":   Redmean,
+	"ycbcr":     YCbCr,
+	"cie76":     CIE76,
+	"cie94":     CIE94,
+	"ciede2000": CIEDE2000,
+}
+
+func Lookup(name string) (Func, error) {
+	f, ok := Metrics[name]
+	if !ok {
+		return nil, fmt.Errorf("colordist: unknown metric %q", name)
+	}
+	return f, nil
+}
+
+// rgb8 returns the 8-bit channels of c as floats.
+func rgb8(c color.Color) (r, g, b float64) {
+	cr, cg, cb, _ := c.RGBA()
+	return float64(cr >> 8), float64(cg >> 8), float64(cb >> 8)
+}
+
+// RGB is the plain Euclidean distance in 8-bit RGB space.
+func RGB(c1, c2 color.Color) float64 {
+	r1, g1, b1 := rgb8(c1)
+	r2, g2, b2 := rgb8(c2)
+	return math.Sqrt(sq(r1-r2) + sq(g1-g2) + sq(b1-b2))
+}
+
+// Redmean is a weighted RGB distance whose weights depend on the mean red
+// level of the two colors, a cheap approximation of perceived difference.
+func Redmean(c1, c2 color.Color) float64 {
+	r1, g1, b1 := rgb8(c1)
+	r2, g2, b2 := rgb8(c2)
+	rm := (r1 + r2) / 2
+	return math.Sqrt((2+rm/256)*sq(r1-r2) + 4*sq(g1-g2) + (2+(255-rm)/256)*sq(b1-b2))
+}
+
+// YCbCr is the Euclidean distance in JPEG's YCbCr space.
+func YCbCr(c1, c2 color.Color) float64 {
+	r1, g1, b1 := rgb8(c1)
+	r2, g2, b2 := rgb8(c2)
+	y1, cb1, cr1 := color.RGBToYCbCr(uint8(r1), uint8(g1), uint8(b1))
+	y2, cb2, cr2 := color.RGBToYCbCr(uint8(r2), uint8(g2), uint8(b2))
+	// subtract as signed values; uint8 arithmetic would wrap around
+	return math.Sqrt(sq(float64(y1)-float64(y2)) + sq(float64(cb1)-float64(cb2)) + sq(float64(cr1)-float64(cr2)))
+}
+
+// CIE76 is the Euclidean distance in CIELAB space (ΔE*ab, 1976).
+func CIE76(c1, c2 color.Color) float64 {
+	l1, a1, b1 := Lab(c1)
+	l2, a2, b2 := Lab(c2)
+	return math.Sqrt(sq(l1-l2) + sq(a1-a2) + sq(b1-b2))
+}
+
+// CIE94 is ΔE*94 with the graphic arts weights.
+func CIE94(c1, c2 color.Color) float64 {
+	const kL, k1, k2 = 1, 0.045, 0.015
+	l1, a1, b1 := Lab(c1)
+	l2, a2, b2 := Lab(c2)
+	ch1 := math.Hypot(a1, b1)
+	ch2 := math.Hypot(a2, b2)
+	dL := l1 - l2
+	dC := ch1 - ch2
+	// ΔH² may come out slightly negative through rounding
+	dH2 := math.Max(0, sq(a1-a2)+sq(b1-b2)-sq(dC))
+	sC := 1 + k1*ch1
+	sH := 1 + k2*ch1
+	return math.Sqrt(sq(dL/kL) + sq(dC/sC) + dH2/sq(sH))
+}
+
+// CIEDE2000 is the CIE ΔE00 color difference.
+func CIEDE2000(c1, c2 color.Color) float64 {
+	l1, a1, b1 := Lab(c1)
+	l2, a2, b2 := Lab(c2)
+	return de2000(l1, a1, b1, l2, a2, b2)
+}
+
+func de2000(l1, a1, b1, l2, a2, b2 float64) float64 {
+	cBar := (math.Hypot(a1, b1) + math.Hypot(a2, b2)) / 2
+	g := 0.5 * (1 - math.Sqrt(math.Pow(cBar, 7)/(math.Pow(cBar, 7)+math.Pow(25, 7))))
+	a1p := (1 + g) * a1
+	a2p := (1 + g) * a2
+	c1p := math.Hypot(a1p, b1)
+	c2p := math.Hypot(a2p, b2)
+	h1p := hueAngle(b1, a1p)
+	h2p := hueAngle(b2, a2p)
+
+	dLp := l2 - l1
+	dCp := c2p - c1p
+	var dhp float64
+	switch {
+	case c1p*c2p == 0:
+		dhp = 0
+	case math.Abs(h2p-h1p) <= 180:
+		dhp = h2p - h1p
+	case h2p-h1p > 180:
+		dhp = h2p - h1p - 360
+	default:
+		dhp = h2p - h1p + 360
+	}
+	dHp := 2 * math.Sqrt(c1p*c2p) * math.Sin(rad(dhp/2))
+
+	lBarp := (l1 + l2) / 2
+	cBarp := (c1p + c2p) / 2
+	var hBarp float64
+	switch {
+	case c1p*c2p == 0:
+		hBarp = h1p + h2p
+	case math.Abs(h1p-h2p) <= 180:
+		hBarp = (h1p + h2p) / 2
+	case h1p+h2p < 360:
+		hBarp = (h1p + h2p + 360) / 2
+	default:
+		hBarp = (h1p + h2p - 360) / 2
+	}
+
+	t := 1 - 0.17*math.Cos(rad(hBarp-30)) + 0.24*math.Cos(rad(2*hBarp)) +
+		0.32*math.Cos(rad(3*hBarp+6)) - 0.20*math.Cos(rad(4*hBarp-63))
+	dTheta := 30 * math.Exp(-sq((hBarp-275)/25))
+	rC := 2 * math.Sqrt(math.Pow(cBarp, 7)/(math.Pow(cBarp, 7)+math.Pow(25, 7)))
+	sL := 1 + 0.015*sq(lBarp-50)/math.Sqrt(20+sq(lBarp-50))
+	sC := 1 + 0.045*cBarp
+	sH := 1 + 0.015*cBarp*t
+	rT := -math.Sin(rad(2*dTheta)) * rC
+
+	return math.Sqrt(sq(dLp/sL) + sq(dCp/sC) + sq(dHp/sH) + rT*(dCp/sC)*(dHp/sH))
+}
+
+// Lab converts c from sRGB to CIELAB under the D65 white point.
+func Lab(c color.Color) (l, a, b float64) {
+	cr, cg, cb, _ := c.RGBA()
+	r := linear(float64(cr) / 0xffff)
+	g := linear(float64(cg) / 0xffff)
+	bl := linear(float64(cb) / 0xffff)
+
+	x := (0.4124564*r + 0.3575761*g + 0.1804375*bl) / 0.95047
+	y := 0.2126729*r + 0.7151522*g + 0.0721750*bl
+	z := (0.0193339*r + 0.1191920*g + 0.9503041*bl) / 1.08883
+
+	fx, fy, fz := labF(x), labF(y), labF(z)
+	return 116*fy - 16, 500 * (fx - fy), 200 * (fy - fz)
+}
+
+// linear undoes the sRGB gamma curve.
+func linear(v float64) float64 {
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+func labF(t float64) float64 {
+	const delta = 6.0 / 29
+	if t > delta*delta*delta {
+		return math.Cbrt(t)
+	}
+	return t/(3*delta*delta) + 4.0/29
+}
+
+// hueAngle returns atan2(y, x) in degrees in [0, 360).
+func hueAngle(y, x float64) float64 {
+	if x == 0 && y == 0 {
+		return 0
+	}
+	h := math.Atan2(y, x) * 180 / math.Pi
+	if h < 0 {
+		h += 360
+	}
+	return h
+}
+
+func rad(deg float64) float64 {
+	return deg * math.Pi / 180
+}
+
+func sq(x float64) float64 {
+	return x * x
+}
@@ -0,0 +1,64 @@
+package pixl
+
+import (
+	"image/color"
+	"sync"
+)
+
+// DefaultCacheLimit is the number of entries a DistCache holds when no limit
+// is given.
+const DefaultCacheLimit = 1 << 20
+
+// DistCache memoizes a color distance function. Tiles take few distinct
+// colors compared to the number of neighbor pairs evaluated, so most calls
+// during clustering become a map lookup. It is safe for concurrent use.
+type DistCache struct {
+	dist  func(c1, c2 color.Color) float64
+	limit int
+	mu    sync.RWMutex
+	m     map[uint64]float64
+}
+
+// NewDistCache wraps dist. Once limit entries have been stored the cache is
+// emptied and starts over.
+func NewDistCache(dist func(c1, c2 color.Color) float64, limit int) *DistCache {
+	if limit <= 0 {
+		limit = DefaultCacheLimit
+	}
+	return &DistCache{dist: dist, limit: limit, m: make(map[uint64]float64)}
+}
+
+// Dist returns the wrapped distance between c1 and c2. The pair is cached
+// once for both orders, so the wrapped function is always given the two
+// colors in the same order; asymmetric distances such as CIE94 then give the
+// same answer whichever way round they are asked.
+func (c *DistCache) Dist(c1, c2 color.Color) float64 {
+	k1, k2 := colorKey(c1), colorKey(c2)
+	if k1 > k2 {
+		k1, k2 = k2, k1
+		c1, c2 = c2, c1
+	}
+	key := uint64(k1)<<32 | uint64(k2)
+	c.mu.RLock()
+	d, ok := c.m[key]
+	c.mu.RUnlock()
+	if ok {
+		return d
+	}
+	d = c.dist(c1, c2)
+	c.mu.Lock()
+	if len(c.m) >= c.limit {
+		c.m = make(map[uint64]float64)
+	}
+	c.m[key] = d
+	c.mu.Unlock()
+	return d
+}
+
+func colorKey(c color.Color) uint32 {
+	rgba, ok := c.(color.RGBA)
+	if !ok {
+		rgba = color.RGBAModel.Convert(c).(color.RGBA)
+	}
+	return uint32(rgba.R)<<24 | uint32(rgba.G)<<16 | uint32(rgba.B)<<8 | uint32(rgba.A)
+}
@@ -0,0 +1,235 @@
+package pixl
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"strings"
+)
+
+// DitherFunc maps every tile of p to a color of pal, arranging the choices
+// so that groups of tiles average out closer to the original colors than
+// flat nearest-color fills.
+type DitherFunc func(p *Pixl, pal color.Palette, dist func(c1, c2 color.Color) float64)
+
+// Dithers maps the names accepted by LookupDither to their functions. Bayer
+// matrices of other sizes are available as "bayer:N".
+var Dithers = map[string]DitherFunc{
+	"floyd-steinberg": Diffuse(FloydSteinberg),
+	"atkinson":        Diffuse(Atkinson),
+	"sierra":          Diffuse(Sierra),
+	"bayer":           Bayer(4),
+}
+
+func LookupDither(spec string) (DitherFunc, error) {
+	name, arg, hasArg := strings.Cut(spec, ":")
+	if name == "bayer" && hasArg {
+		var n int
+		if _, err := fmt.Sscanf(arg, "%d", &n); err != nil || n < 2 || n > 16 || n&(n-1) != 0 {
+			return nil, fmt.Errorf("pixl: bayer matrix size must be 2, 4, 8 or 16, not %q", arg)
+		}
+		return Bayer(n), nil
+	}
+	f, ok := Dithers[spec]
+	if !ok {
+		return nil, fmt.Errorf("pixl: unknown dither %q", spec)
+	}
+	return f, nil
+}
+
+// Dither quantizes the tiles to pal with f. It changes only colors, so
+// Perm is left alone.
+func (p *Pixl) Dither(pal color.Palette, dist func(c1, c2 color.Color) float64, f DitherFunc) {
+	f(p, pal, dist)
+}
+
+// Kernel says how error diffusion shares out the error of a tile among the
+// tiles after it.
+type Kernel struct {
+	// Offsets of the tiles receiving error, in the direction of the scan
+	// and down, and their weights
+	Taps []Tap
+	// What the weights are divided by. Weights that add up to less than the
+	// divisor throw the rest of the error away.
+	Divisor float64
+}
+
+type Tap struct {
+	DX, DY int
+	Weight float64
+}
+
+var (
+	FloydSteinberg = Kernel{[]Tap{
+		{1, 0, 7},
+		{-1, 1, 3}, {0, 1, 5}, {1, 1, 1},
+	}, 16}
+	// Atkinson diffuses only three quarters of the error, which keeps
+	// contrast at the cost of detail in the shadows and highlights.
+	Atkinson = Kernel{[]Tap{
+		{1, 0, 1}, {2, 0, 1},
+		{-1, 1, 1}, {0, 1, 1}, {1, 1, 1},
+		{0, 2, 1},
+	}, 8}
+	Sierra = Kernel{[]Tap{
+		{1, 0, 5}, {2, 0, 3},
+		{-2, 1, 2}, {-1, 1, 4}, {0, 1, 5}, {1, 1, 4}, {2, 1, 2},
+		{-1, 2, 2}, {0, 2, 3}, {1, 2, 2},
+	}, 32}
+)
+
+// Diffuse returns a DitherFunc that visits the tiles in order, snaps each to
+// its nearest palette color and passes the difference on to the tiles not
+// yet visited. Square grids are scanned in alternating directions and
+// spread the error by k; other topologies spread the same share of it
+// evenly among the later neighbors of each tile.
+func Diffuse(k Kernel) DitherFunc {
+	return func(p *Pixl, pal color.Palette, dist func(c1, c2 color.Color) float64) {
+		if len(pal) == 0 {
+			return
+		}
+		errs := make([][3]float64, len(p.Tiles))
+		visit := func(bl image.Point) [3]float64 {
+			i := p.index(bl)
+			c := p.Tiles[i]
+			want := [3]float64{
+				clamp(float64(c.R)+errs[i][0], 0, float64(c.A)),
+				clamp(float64(c.G)+errs[i][1], 0, float64(c.A)),
+				clamp(float64(c.B)+errs[i][2], 0, float64(c.A)),
+			}
+			q := nearestColor(pal, color.RGBA{uint8(want[0] + 0.5), uint8(want[1] + 0.5), uint8(want[2] + 0.5), c.A}, dist)
+			p.Tiles[i] = q
+			return [3]float64{want[0] - float64(q.R), want[1] - float64(q.G), want[2] - float64(q.B)}
+		}
+		spread := func(bl image.Point, e [3]float64, w float64) {
+			i := p.index(bl)
+			for ch := range e {
+				errs[i][ch] += e[ch] * w
+			}
+		}
+
+		if !p.rectangular() {
+			total := 0.0
+			for _, t := range k.Taps {
+				total += t.Weight
+			}
+			total /= k.Divisor
+			for i := range p.Tiles {
+				bl := p.GetPoint(i)
+				if !p.drawn(bl) {
+					continue
+				}
+				e := visit(bl)
+				var later []image.Point
+				for _, n := range p.Neighbors(bl) {
+					if p.index(n) > i {
+						later = append(later, n)
+					}
+				}
+				for _, n := range later {
+					spread(n, e, total/float64(len(later)))
+				}
+			}
+			return
+		}
+
+		for y := 0; y < p.NumRows; y++ {
+			// serpentine: odd rows go right to left, with the kernel mirrored
+			dir, x0 := 1, 0
+			if y%2 == 1 {
+				dir, x0 = -1, p.NumCols-1
+			}
+			for x := x0; x >= 0 && x < p.NumCols; x += dir {
+				e := visit(image.Pt(x, y))
+				for _, t := range k.Taps {
+					n := image.Pt(x+t.DX*dir, y+t.DY)
+					if p.inBounds(n) {
+						spread(n, e, t.Weight/k.Divisor)
+					}
+				}
+			}
+		}
+	}
+}
+
+// Bayer returns an ordered DitherFunc using the n by n Bayer threshold
+// matrix, n a power of two. Each tile is nudged by the threshold at its
+// position before being snapped to the nearest palette color, by about the
+// spacing between palette colors, which gives a regular cross-hatch
+// instead of the noise of error diffusion.
+func Bayer(n int) DitherFunc {
+	m := bayerMatrix(n)
+	return func(p *Pixl, pal color.Palette, dist func(c1, c2 color.Color) float64) {
+		if len(pal) == 0 {
+			return
+		}
+		// the step between colors of a palette spread evenly over the cube
+		step := 255 / math.Cbrt(float64(len(pal)))
+		for i, c := range p.Tiles {
+			pos := p.ditherPos(p.GetPoint(i))
+			t := (m[pos.Y&(n-1)][pos.X&(n-1)] - 0.5) * step
+			want := color.RGBA{
+				uint8(clamp(float64(c.R)+t, 0, float64(c.A)) + 0.5),
+				uint8(clamp(float64(c.G)+t, 0, float64(c.A)) + 0.5),
+				uint8(clamp(float64(c.B)+t, 0, float64(c.A)) + 0.5),
+				c.A,
+			}
+			p.Tiles[i] = nearestColor(pal, want, dist)
+		}
+	}
+}
+
+// bayerMatrix builds the n by n Bayer matrix with thresholds in (0, 1).
+func bayerMatrix(n int) [][]float64 {
+	idx := [][]int{{0}}
+	for size := 1; size < n; size *= 2 {
+		next := make([][]int, 2*size)
+		for y := range next {
+			next[y] = make([]int, 2*size)
+			for x := range next[y] {
+				v := 4 * idx[y%size][x%size]
+				// quadrants in the order 0 2 / 3 1
+				next[y][x] = v + [2][2]int{{0, 2}, {3, 1}}[y/size][x/size]
+			}
+		}
+		idx = next
+	}
+	m := make([][]float64, len(idx))
+	for y, row := range idx {
+		m[y] = make([]float64, len(row))
+		for x, v := range row {
+			m[y][x] = (float64(v) + 0.5) / float64(len(idx)*len(idx))
+		}
+	}
+	return m
+}
+
+// ditherPos is where a tile falls on the threshold matrix: its column and
+// row, except for cells, whose single row says nothing about where they
+// are, so their centers are used instead.
+func (p *Pixl) ditherPos(bl image.Point) image.Point {
+	if !p.irregular() || p.BlockW < 1 {
+		return bl
+	}
+	r := p.GetBlock(bl)
+	c := r.Min.Add(r.Max).Div(2)
+	return image.Pt(c.X/p.BlockW, c.Y/p.BlockW)
+}
+
+// nearestColor is the color of pal closest to c under dist.
+func nearestColor(pal color.Palette, c color.Color, dist func(c1, c2 color.Color) float64) color.RGBA {
+	var q color.RGBA
+	best := math.Inf(1)
+	for _, pc := range pal {
+		if d := dist(c, pc); d < best {
+			best = d
+			q = color.RGBAModel.Convert(pc).(color.RGBA)
+		}
+	}
+	return q
+}
+
+func clamp(v, lo, hi float64) float64 {
+	return math.Max(lo, math.Min(hi, v))
+}
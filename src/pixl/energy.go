@@ -0,0 +1,59 @@
+package pixl
+
+import (
+	"image"
+	"image/color"
+)
+
+// Stats describes one iteration of a clustering run.
+type Stats struct {
+	Iter     int     `json:"iter"`
+	Energy   float64 `json:"energy"`
+	Delta    float64 `json:"delta"`
+	Proposed int     `json:"proposed"`
+	Accepted int     `json:"accepted"`
+}
+
+// Energy is the total dissimilarity of the arrangement: the sum of dist over
+// every pair of neighboring tiles. Lower is smoother.
+func Energy(p Pixeler, dist func(c1, c2 color.Color) float64) float64 {
+	cols, rows := p.Dims()
+	e := 0.0
+	for y := 0; y < rows; y++ {
+		for x := 0; x < cols; x++ {
+			pt := image.Pt(x, y)
+			c := p.ColorAt(pt)
+			for _, n := range p.Neighbors(pt) {
+				// count each pair once, from the tile that comes first
+				if n.Y > y || n.Y == y && n.X > x {
+					e += dist(c, p.ColorAt(n))
+				}
+			}
+		}
+	}
+	return e
+}
+
+// SwapDelta is the change in Energy that swapping the tiles at a and b would
+// cause.
+func SwapDelta(p Pixeler, a, b image.Point, dist func(c1, c2 color.Color) float64) float64 {
+	if a == b {
+		return 0
+	}
+	ca, cb := p.ColorAt(a), p.ColorAt(b)
+	return moveDelta(p, a, b, ca, cb, dist) + moveDelta(p, b, a, cb, ca, dist)
+}
+
+// moveDelta is the change in the energy around from when its color changes
+// from old to new, ignoring the pair (from, other) which a swap leaves alone.
+func moveDelta(p Pixeler, from, other image.Point, old, new color.Color, dist func(c1, c2 color.Color) float64) float64 {
+	d := 0.0
+	for _, n := range p.Neighbors(from) {
+		if n == other {
+			continue
+		}
+		cn := p.ColorAt(n)
+		d += dist(new, cn) - dist(old, cn)
+	}
+	return d
+}
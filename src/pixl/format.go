@@ -0,0 +1,106 @@
+package pixl
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// Formats lists the output formats EncodeAs understands.
+var Formats = []string{"png", "jpeg", "gif", "ppm", "pam", "svg"}
+
+// extensions recognized by FormatFromName
+var formatExts = map[string]string{
+	".png":  "png",
+	".jpg":  "jpeg",
+	".jpeg": "jpeg",
+	".gif":  "gif",
+	".ppm":  "ppm",
+	".pam":  "pam",
+	".svg":  "svg",
+}
+
+// FormatFromName picks the output format from a file name's extension.
+func FormatFromName(name string) (string, error) {
+	ext := strings.ToLower(filepath.Ext(name))
+	if f, ok := formatExts[ext]; ok {
+		return f, nil
+	}
+	return "", fmt.Errorf("pixl: can't tell output format from extension %q of %s; want one of %s", ext, name, strings.Join(Formats, ", "))
+}
+
+// EncodeOptions tunes the encoders that take options.
+type EncodeOptions struct {
+	// JPEG quality, 1 to 100; zero means jpeg.DefaultQuality
+	Quality int
+}
+
+// EncodeAs writes the rendered grid in the given format. Only PNG carries
+// p.Meta.
+func (p *Pixl) EncodeAs(w io.Writer, format string, opts EncodeOptions) error {
+	img := p.Render()
+	switch format {
+	case "png":
+		return encodePNG(w, img, p.Meta)
+	case "jpeg":
+		q := opts.Quality
+		if q == 0 {
+			q = jpeg.DefaultQuality
+		}
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: q})
+	case "gif":
+		if p.Tiles == nil {
+			return gif.Encode(w, img, nil)
+		}
+		return gif.Encode(w, gifFrame(img, p.Tiles, p.painted()), nil)
+	case "ppm":
+		return encodeNetpbm(w, img, false)
+	case "pam":
+		return encodeNetpbm(w, img, true)
+	case "svg":
+		if p.Tiles == nil {
+			return fmt.Errorf("pixl: SVG output needs a pixelated image")
+		}
+		return p.WriteSVG(w)
+	}
+	return fmt.Errorf("pixl: unknown output format %q", format)
+}
+
+// encodeNetpbm writes img uncompressed, as binary PPM (P6) or, with alpha,
+// as PAM (P7).
+func encodeNetpbm(w io.Writer, img *image.RGBA, alpha bool) error {
+	b := img.Bounds()
+	bw := bufio.NewWriter(w)
+	depth := 3
+	if alpha {
+		depth = 4
+		fmt.Fprintf(bw, "P7\nWIDTH %d\nHEIGHT %d\nDEPTH 4\nMAXVAL 255\nTUPLTYPE RGB_ALPHA\nENDHDR\n", b.Dx(), b.Dy())
+	} else {
+		fmt.Fprintf(bw, "P6\n%d %d\n255\n", b.Dx(), b.Dy())
+	}
+	row := make([]byte, 0, b.Dx()*depth)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		row = row[:0]
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := img.RGBAAt(x, y)
+			if alpha {
+				// PAM stores straight alpha, image.RGBA premultiplied
+				n := color.NRGBAModel.Convert(c).(color.NRGBA)
+				row = append(row, n.R, n.G, n.B, n.A)
+			} else {
+				// premultiplied values are the color composited onto black
+				row = append(row, c.R, c.G, c.B)
+			}
+		}
+		if _, err := bw.Write(row); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,455 @@
+package pixl
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math/rand"
+)
+
+// Remainder says what happens to the pixels left over on the right and bottom
+// edges when the image size is not a multiple of the block size.
+type Remainder int
+
+const (
+	// Crop drops the leftover pixels.
+	Crop Remainder = iota
+	// Pad adds a partial row/column of blocks, filled out with edge pixels.
+	// Given a number of blocks, it rounds the block size up, which can
+	// leave fewer blocks than asked for.
+	Pad
+	// Stretch widens the last column and heightens the last row to cover the
+	// leftover pixels.
+	Stretch
+)
+
+var remainderNames = []string{"crop", "pad", "stretch"}
+
+func (r Remainder) String() string {
+	if r < 0 || int(r) >= len(remainderNames) {
+		return fmt.Sprintf("Remainder(%d)", int(r))
+	}
+	return remainderNames[r]
+}
+
+func ParseRemainder(name string) (Remainder, error) {
+	for i, n := range remainderNames {
+		if n == name {
+			return Remainder(i), nil
+		}
+	}
+	return Crop, fmt.Errorf("pixl: unknown remainder policy %q", name)
+}
+
+// Spec describes the desired grid. Give either a number of columns (and
+// optionally rows) or a block size in pixels; whatever is missing is derived
+// from the image size. Blocks are square unless both dimensions are given.
+type Spec struct {
+	Cols, Rows     int
+	BlockW, BlockH int
+	Remainder      Remainder
+	// Shape of the tiles; square when nil
+	Topology Topology
+	// If set, Pixl only pixelates the tiles touching the mask, fading into
+	// the source over Feather pixels at their edges
+	Mask    Mask
+	Feather int
+}
+
+// Grid describes how an image is divided into tiles. It is shared by the
+// Pixeler implementations in this package. Tiles are addressed by column and
+// row whatever their shape; BlockW and BlockH are the size of one tile.
+type Grid struct {
+	NumCols   int
+	NumRows   int
+	BlockW    int
+	BlockH    int
+	Remainder Remainder
+	// Size of the image being divided
+	Width, Height int
+	// Shape of the tiles; square when nil
+	Topology Topology
+
+	// neighbors of each tile, by index
+	neighbors [][]image.Point
+	// blank[i] is true for tiles that cover no pixel of the image, such as
+	// the corners of a HexAxial grid; nil when there are none
+	blank []bool
+}
+
+// Layout divides bounds into tiles according to s.
+func (g *Grid) Layout(bounds image.Rectangle, s Spec) {
+	g.Width, g.Height = bounds.Dx(), bounds.Dy()
+	g.Remainder = s.Remainder
+	g.Topology = s.Topology
+	g.topology().Layout(g, s)
+	g.blank = g.findBlank()
+
+	g.neighbors = make([][]image.Point, g.NumCols*g.NumRows)
+	for i := range g.neighbors {
+		if !g.drawn(g.GetPoint(i)) {
+			continue
+		}
+		var ns []image.Point
+		for _, n := range g.topology().Neighbors(g, g.GetPoint(i)) {
+			if g.inBounds(n) && g.drawn(n) {
+				ns = append(ns, n)
+			}
+		}
+		g.neighbors[i] = ns
+	}
+}
+
+// findBlank marks the tiles no pixel of the image falls in, or returns nil
+// if there are none.
+func (g *Grid) findBlank() []bool {
+	if g.rectangular() || g.irregular() {
+		return nil
+	}
+	blank := make([]bool, g.NumCols*g.NumRows)
+	for i := range blank {
+		blank[i] = true
+	}
+	n := len(blank)
+	for y := 0; y < g.Height; y++ {
+		for x := 0; x < g.Width; x++ {
+			if bl := g.TileAt(x, y); g.inBounds(bl) && blank[g.index(bl)] {
+				blank[g.index(bl)] = false
+				n--
+			}
+		}
+	}
+	if n == 0 {
+		return nil
+	}
+	return blank
+}
+
+// layoutImage is Layout for img, first segmenting it if the topology needs
+// to.
+func (g *Grid) layoutImage(img image.Image, s Spec, rnd *rand.Rand) {
+	if seg, ok := s.Topology.(Segmenter); ok {
+		seg.Segment(img, s, rnd)
+	}
+	g.Layout(img.Bounds(), s)
+}
+
+func (g *Grid) topology() Topology {
+	if g.Topology == nil {
+		return Square{}
+	}
+	return g.Topology
+}
+
+// Square is the Topology of rectangular blocks, each touching the 8 around
+// it. The Spec's Remainder decides what happens at the edges.
+type Square struct{}
+
+func (Square) Layout(g *Grid, s Spec) {
+	g.NumCols, g.BlockW = layoutAxis(g.Width, s.Cols, s.BlockW, s.Remainder)
+	switch {
+	case s.Rows > 0 || s.BlockH > 0:
+		g.NumRows, g.BlockH = layoutAxis(g.Height, s.Rows, s.BlockH, s.Remainder)
+	default:
+		// square blocks
+		g.NumRows, g.BlockH = layoutAxis(g.Height, 0, g.BlockW, s.Remainder)
+	}
+}
+
+// layoutAxis works out the number of blocks and block length along an axis
+// of the given size, from either the desired count n or block length bs.
+func layoutAxis(size, n, bs int, rem Remainder) (int, int) {
+	if size < 1 {
+		return 0, 1
+	}
+	if n > 0 {
+		if n > size {
+			n = size
+		}
+		if rem == Pad {
+			// round the block up and drop any blocks that would only be
+			// padding, so just the last one is partial
+			bs := (size + n - 1) / n
+			return (size + bs - 1) / bs, bs
+		}
+		return n, size / n
+	}
+	if bs < 1 {
+		bs = 1
+	}
+	if bs > size {
+		bs = size
+	}
+	switch rem {
+	case Pad:
+		return (size + bs - 1) / bs, bs
+	default:
+		return size / bs, bs
+	}
+}
+
+func (Square) Bounds(g *Grid) image.Rectangle {
+	if g.Remainder == Stretch {
+		return image.Rect(0, 0, g.Width, g.Height)
+	}
+	return image.Rect(0, 0, g.NumCols*g.BlockW, g.NumRows*g.BlockH)
+}
+
+func (Square) TileAt(g *Grid, x, y int) image.Point {
+	pt := image.Pt(floorDiv(x, g.BlockW), floorDiv(y, g.BlockH))
+	if g.Remainder == Stretch {
+		if pt.X >= g.NumCols && x < g.Width {
+			pt.X = g.NumCols - 1
+		}
+		if pt.Y >= g.NumRows && y < g.Height {
+			pt.Y = g.NumRows - 1
+		}
+	}
+	return pt
+}
+
+func (Square) Block(g *Grid, bl image.Point) image.Rectangle {
+	r := image.Rect(bl.X*g.BlockW, bl.Y*g.BlockH, (bl.X+1)*g.BlockW, (bl.Y+1)*g.BlockH)
+	if g.Remainder == Stretch {
+		if bl.X == g.NumCols-1 {
+			r.Max.X = g.Width
+		}
+		if bl.Y == g.NumRows-1 {
+			r.Max.Y = g.Height
+		}
+	}
+	return r
+}
+
+// offsets to the 8 neighbors of a square tile
+var neighborhood = []image.Point{
+	{-1, -1}, {0, -1}, {1, -1},
+	{-1, 0}, {1, 0},
+	{-1, 1}, {0, 1}, {1, 1},
+}
+
+func (Square) Neighbors(g *Grid, bl image.Point) []image.Point {
+	ns := make([]image.Point, len(neighborhood))
+	for i, d := range neighborhood {
+		ns[i] = bl.Add(d)
+	}
+	return ns
+}
+
+func (sq Square) Polygon(g *Grid, bl image.Point) []Vertex {
+	r := sq.Block(g, bl)
+	return []Vertex{
+		{float64(r.Min.X), float64(r.Min.Y)}, {float64(r.Max.X), float64(r.Min.Y)},
+		{float64(r.Max.X), float64(r.Max.Y)}, {float64(r.Min.X), float64(r.Max.Y)},
+	}
+}
+
+// floorDiv divides rounding toward negative infinity.
+func floorDiv(a, b int) int {
+	q := a / b
+	if a%b != 0 && (a < 0) != (b < 0) {
+		q--
+	}
+	return q
+}
+
+// Bounds is the size of the rendered grid.
+func (g *Grid) Bounds() image.Rectangle {
+	return g.topology().Bounds(g)
+}
+
+func (g *Grid) Dims() (cols, rows int) {
+	return g.NumCols, g.NumRows
+}
+
+func (g *Grid) GetPoint(bn int) image.Point {
+	x := (bn % g.NumCols)
+	y := (bn / g.NumCols)
+	return image.Pt(x, y)
+}
+
+// index is the inverse of GetPoint
+func (g *Grid) index(bl image.Point) int {
+	return bl.Y*g.NumCols + bl.X
+}
+
+// GetBlock returns the bounding box of a tile. Tiles that aren't square
+// cover only part of it, and may reach past the edges of Bounds.
+func (g *Grid) GetBlock(bl image.Point) image.Rectangle {
+	return g.topology().Block(g, bl)
+}
+
+// TileAt returns the tile covering pixel (x, y), which is outside the grid
+// for pixels past its edges.
+func (g *Grid) TileAt(x, y int) image.Point {
+	return g.topology().TileAt(g, x, y)
+}
+
+// Neighbors returns the tiles in the grid adjacent to bl.
+func (g *Grid) Neighbors(bl image.Point) []image.Point {
+	if i := g.index(bl); g.inBounds(bl) && i < len(g.neighbors) {
+		return g.neighbors[i]
+	}
+	var ns []image.Point
+	for _, n := range g.topology().Neighbors(g, bl) {
+		if g.inBounds(n) {
+			ns = append(ns, n)
+		}
+	}
+	return ns
+}
+
+// Polygon returns the outline of tile bl in pixels.
+func (g *Grid) Polygon(bl image.Point) []Vertex {
+	return g.topology().Polygon(g, bl)
+}
+
+// rectangular reports whether every tile fills its GetBlock.
+func (g *Grid) rectangular() bool {
+	_, ok := g.topology().(Square)
+	return ok
+}
+
+// irregular reports whether the tiles are Cells, which aren't laid out in
+// rows and columns.
+func (g *Grid) irregular() bool {
+	_, ok := g.topology().(interface{ cells() *Cells })
+	return ok
+}
+
+// TileMask returns an alpha mask of the pixels of GetBlock(bl) that tile bl
+// covers, for use with draw.DrawMask, or nil if it covers all of them.
+func (g *Grid) TileMask(bl image.Point) image.Image {
+	if g.rectangular() {
+		return nil
+	}
+	return &tileMask{g, bl}
+}
+
+func (g *Grid) inBounds(pt image.Point) bool {
+	return pt.X >= 0 && pt.X < g.NumCols && pt.Y >= 0 && pt.Y < g.NumRows
+}
+
+// drawn reports whether tile bl covers any of the image. Tiles that don't
+// have no neighbors and are never moved.
+func (g *Grid) drawn(bl image.Point) bool {
+	return g.blank == nil || !g.blank[g.index(bl)]
+}
+
+// isDrawn is Grid.drawn for any Pixeler, taking tiles to be drawn unless p
+// is laid out by a Grid that says otherwise.
+func isDrawn(p Pixeler, bl image.Point) bool {
+	if g, ok := p.(interface{ drawn(image.Point) bool }); ok {
+		return g.drawn(bl)
+	}
+	return true
+}
+
+// source returns img as seen by the grid: anchored at the origin and, when
+// padding square blocks, extended by repeating the edge pixels. Tiles of
+// other shapes that reach past the edges are clipped to the image instead,
+// so they take their color from their own pixels only.
+func (g *Grid) source(img image.Image) image.Image {
+	if g.rectangular() && g.Remainder != Pad && img.Bounds().Min == image.ZP {
+		return img
+	}
+	return &edgeImage{img, g.Bounds()}
+}
+
+// aggregate fills every tile with f applied to its part of img, going column
+// by column.
+func (g *Grid) aggregate(img image.Image, f AggregateFunc, fill func(bl image.Point, c color.Color)) {
+	src := g.source(img)
+	for x := 0; x < g.NumCols; x++ {
+		for y := 0; y < g.NumRows; y++ {
+			bl := image.Pt(x, y)
+			if g.rectangular() {
+				fill(bl, f(src, g.GetBlock(bl)))
+			} else {
+				fill(bl, f(&tileImage{src, g, bl}, g.GetBlock(bl)))
+			}
+		}
+	}
+}
+
+// render draws each tile into dst in the color at returns for its index.
+func (g *Grid) render(dst draw.Image, at func(i int) color.Color) {
+	if g.rectangular() {
+		for i := 0; i < g.NumCols*g.NumRows; i++ {
+			draw.Draw(dst, g.GetBlock(g.GetPoint(i)), &image.Uniform{at(i)}, image.ZP, draw.Src)
+		}
+		return
+	}
+	b := dst.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if bl := g.TileAt(x, y); g.inBounds(bl) {
+				dst.Set(x, y, at(g.index(bl)))
+			}
+		}
+	}
+}
+
+// tileImage is the part of an image covered by one tile. It is Shaped, so
+// aggregate functions skip the pixels of other tiles.
+type tileImage struct {
+	image.Image
+	g  *Grid
+	bl image.Point
+}
+
+func (t *tileImage) In(x, y int) bool {
+	return t.g.TileAt(x, y) == t.bl
+}
+
+// tileMask is opaque over one tile and transparent elsewhere.
+type tileMask struct {
+	g  *Grid
+	bl image.Point
+}
+
+func (m *tileMask) ColorModel() color.Model {
+	return color.AlphaModel
+}
+
+func (m *tileMask) Bounds() image.Rectangle {
+	return m.g.GetBlock(m.bl)
+}
+
+func (m *tileMask) At(x, y int) color.Color {
+	if m.g.TileAt(x, y) == m.bl {
+		return color.Opaque
+	}
+	return color.Transparent
+}
+
+// edgeImage extends an image infinitely by clamping coordinates to its edges.
+type edgeImage struct {
+	img    image.Image
+	bounds image.Rectangle
+}
+
+func (e *edgeImage) ColorModel() color.Model {
+	return e.img.ColorModel()
+}
+
+func (e *edgeImage) Bounds() image.Rectangle {
+	return e.bounds
+}
+
+func (e *edgeImage) At(x, y int) color.Color {
+	b := e.img.Bounds()
+	x, y = x+b.Min.X, y+b.Min.Y
+	if x < b.Min.X {
+		x = b.Min.X
+	} else if x >= b.Max.X {
+		x = b.Max.X - 1
+	}
+	if y < b.Min.Y {
+		y = b.Min.Y
+	} else if y >= b.Max.Y {
+		y = b.Max.Y - 1
+	}
+	return e.img.At(x, y)
+}
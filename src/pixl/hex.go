@@ -0,0 +1,160 @@
+package pixl
+
+import (
+	"image"
+	"math"
+)
+
+// Both hexagonal topologies use pointy-topped hexagons BlockW wide and
+// BlockH tall, with rows BlockH*3/4 apart and the center of tile (0, 0) on
+// the top left corner of the image, so tiles along the edges are clipped.
+// Every tile touches the six around it.
+
+// HexOffset lays hexagons out in rows and columns with every odd row pushed
+// right by half a tile, so the grid covers the image as a rectangle.
+type HexOffset struct{}
+
+// Columns are a tile apart with the first reaching half a tile left of the
+// image, and rows three quarters of a tile apart with the points of the
+// first half a tile above it.
+var hexCols, hexRows = spacing{1, 0.5}, spacing{0.75, 0.5}
+
+func (h HexOffset) Layout(g *Grid, s Spec) {
+	g.BlockW, g.BlockH = tileSize(g, s, 2/math.Sqrt(3), hexCols, hexRows)
+	cols, rows := extent(g, func(x, y int) image.Point { return h.TileAt(g, x, y) })
+	g.NumCols, g.NumRows = count(cols, s.Cols, s.BlockW), count(rows, s.Rows, s.BlockH)
+}
+
+func (HexOffset) Bounds(g *Grid) image.Rectangle {
+	return image.Rect(0, 0, g.Width, g.Height)
+}
+
+func (HexOffset) TileAt(g *Grid, x, y int) image.Point {
+	q, r := axialAt(g, x, y)
+	return image.Pt(q+(r-r&1)/2, r)
+}
+
+func (h HexOffset) Block(g *Grid, bl image.Point) image.Rectangle {
+	return hexBlock(g, h.axial(bl))
+}
+
+func (h HexOffset) Neighbors(g *Grid, bl image.Point) []image.Point {
+	ns := make([]image.Point, len(axialDirs))
+	for i, d := range axialDirs {
+		a := h.axial(bl).Add(d)
+		ns[i] = image.Pt(a.X+(a.Y-a.Y&1)/2, a.Y)
+	}
+	return ns
+}
+
+func (h HexOffset) Polygon(g *Grid, bl image.Point) []Vertex {
+	return hexPolygon(g, h.axial(bl))
+}
+
+// axial converts a column and row to axial coordinates.
+func (HexOffset) axial(bl image.Point) image.Point {
+	return image.Pt(bl.X-(bl.Y-bl.Y&1)/2, bl.Y)
+}
+
+// HexAxial addresses hexagons by axial coordinates, so each row starts half
+// a tile further right than the one above and the grid is a rhombus. Enough
+// columns are added for it to cover the image; the tiles in the corners of
+// the rhombus lie outside the image, so they are blank and stay put. Unlike
+// HexOffset, the six neighbors of a tile are at the same offsets in every
+// row.
+type HexAxial struct{}
+
+func (h HexAxial) Layout(g *Grid, s Spec) {
+	g.BlockW, g.BlockH = tileSize(g, s, 2/math.Sqrt(3), hexCols, hexRows)
+	cols, rows := extent(g, func(x, y int) image.Point {
+		q, r := axialAt(g, x, y)
+		return image.Pt(q, r)
+	})
+	g.NumRows = count(rows, s.Rows, s.BlockH)
+	g.NumCols = count(cols, s.Cols, s.BlockW) + h.shift(g)
+}
+
+func (HexAxial) Bounds(g *Grid) image.Rectangle {
+	return image.Rect(0, 0, g.Width, g.Height)
+}
+
+func (h HexAxial) TileAt(g *Grid, x, y int) image.Point {
+	q, r := axialAt(g, x, y)
+	return image.Pt(q+h.shift(g), r)
+}
+
+func (h HexAxial) Block(g *Grid, bl image.Point) image.Rectangle {
+	return hexBlock(g, h.axial(g, bl))
+}
+
+func (HexAxial) Neighbors(g *Grid, bl image.Point) []image.Point {
+	ns := make([]image.Point, len(axialDirs))
+	for i, d := range axialDirs {
+		ns[i] = bl.Add(d)
+	}
+	return ns
+}
+
+func (h HexAxial) Polygon(g *Grid, bl image.Point) []Vertex {
+	return hexPolygon(g, h.axial(g, bl))
+}
+
+func (h HexAxial) axial(g *Grid, bl image.Point) image.Point {
+	return image.Pt(bl.X-h.shift(g), bl.Y)
+}
+
+// shift is the column of q = 0. The first tile of row r with any of the
+// image in it is at q = -r/2, rounded toward zero.
+func (HexAxial) shift(g *Grid) int {
+	return max(g.NumRows-1, 0) / 2
+}
+
+// offsets in axial coordinates to the six neighbors of a hexagon
+var axialDirs = []image.Point{
+	{1, 0}, {1, -1}, {0, -1},
+	{-1, 0}, {-1, 1}, {0, 1},
+}
+
+// axialAt returns the axial coordinates of the hexagon covering the center
+// of pixel (x, y).
+func axialAt(g *Grid, x, y int) (q, r int) {
+	// scaled so that hexagons are one unit wide and rows one unit apart
+	u := (float64(x) + 0.5) / float64(g.BlockW)
+	v := (float64(y) + 0.5) / (0.75 * float64(g.BlockH))
+	return hexRound(u-v/2, v)
+}
+
+// hexRound rounds fractional axial coordinates to the nearest hexagon, by
+// rounding all three cube coordinates and fixing up the one that moved most.
+func hexRound(q, r float64) (int, int) {
+	s := -q - r
+	rq, rr, rs := math.Round(q), math.Round(r), math.Round(s)
+	dq, dr, ds := math.Abs(rq-q), math.Abs(rr-r), math.Abs(rs-s)
+	switch {
+	case dq > dr && dq > ds:
+		rq = -rr - rs
+	case dr > ds:
+		rr = -rq - rs
+	}
+	return int(rq), int(rr)
+}
+
+// hexCenter is the center in pixels of the hexagon at axial a.
+func hexCenter(g *Grid, a image.Point) (x, y float64) {
+	return float64(g.BlockW) * (float64(a.X) + float64(a.Y)/2), 0.75 * float64(g.BlockH) * float64(a.Y)
+}
+
+func hexBlock(g *Grid, a image.Point) image.Rectangle {
+	x, y := hexCenter(g, a)
+	w, h := float64(g.BlockW)/2, float64(g.BlockH)/2
+	return image.Rect(int(math.Floor(x-w)), int(math.Floor(y-h)), int(math.Ceil(x+w)), int(math.Ceil(y+h)))
+}
+
+func hexPolygon(g *Grid, a image.Point) []Vertex {
+	x, y := hexCenter(g, a)
+	w, h := float64(g.BlockW)/2, float64(g.BlockH)/2
+	return []Vertex{
+		{x, y - h}, {x + w, y - h/2}, {x + w, y + h/2},
+		{x, y + h}, {x - w, y + h/2}, {x - w, y - h/2},
+	}
+}
@@ -0,0 +1,126 @@
+package pixl
+
+import (
+	"fmt"
+)
+
+// History is a bounded undo/redo log of clustering iterations on a Pixl.
+// Rather than whole images it keeps, for each iteration, the list of swaps
+// that turns the arrangement before it into the one after, worked out from
+// the change in p.Perm. It works no matter how the iteration moved tiles,
+// including from several goroutines at once.
+type History struct {
+	// Maximum number of iterations kept, older ones being forgotten; zero
+	// keeps none, only counting iterations, and a negative limit keeps all
+	Limit int
+
+	steps  [][][2]int
+	pos    int // number of steps in steps currently applied
+	base   int // iteration number before steps[0]
+	before []int
+}
+
+func NewHistory(limit int) *History {
+	return &History{Limit: limit}
+}
+
+// Begin marks the start of an iteration.
+func (h *History) Begin(p *Pixl) {
+	if h.Limit == 0 {
+		return
+	}
+	h.before = append(h.before[:0], p.Perm...)
+}
+
+// Commit records the iteration started by Begin, discarding anything that
+// could have been redone.
+func (h *History) Commit(p *Pixl) {
+	if h.Limit == 0 {
+		h.base++
+		return
+	}
+	swaps := permSwaps(h.before, p.Perm)
+	h.steps = append(h.steps[:h.pos], swaps)
+	h.pos++
+	if h.Limit > 0 && len(h.steps) > h.Limit {
+		drop := len(h.steps) - h.Limit
+		h.steps = append([][][2]int(nil), h.steps[drop:]...)
+		h.pos -= drop
+		h.base += drop
+	}
+}
+
+// Iter is the number of the iteration currently shown.
+func (h *History) Iter() int {
+	return h.base + h.pos
+}
+
+// Undo steps back one iteration, reporting false if there is none left.
+func (h *History) Undo(p *Pixl) bool {
+	if h.pos == 0 {
+		return false
+	}
+	h.pos--
+	swaps := h.steps[h.pos]
+	for i := len(swaps) - 1; i >= 0; i-- {
+		p.swapIndex(swaps[i][0], swaps[i][1])
+	}
+	return true
+}
+
+// Redo reapplies the last undone iteration, reporting false if there is
+// none.
+func (h *History) Redo(p *Pixl) bool {
+	if h.pos == len(h.steps) {
+		return false
+	}
+	for _, s := range h.steps[h.pos] {
+		p.swapIndex(s[0], s[1])
+	}
+	h.pos++
+	return true
+}
+
+// Jump undoes or redoes iterations until iteration iter is shown.
+func (h *History) Jump(p *Pixl, iter int) error {
+	if iter < h.base || iter > h.base+len(h.steps) {
+		return fmt.Errorf("pixl: iteration %d not in history (%d to %d)", iter, h.base, h.base+len(h.steps))
+	}
+	for h.Iter() > iter {
+		h.Undo(p)
+	}
+	for h.Iter() < iter {
+		h.Redo(p)
+	}
+	return nil
+}
+
+func (p *Pixl) swapIndex(i, j int) {
+	p.Swap(p.GetPoint(i), p.GetPoint(j))
+}
+
+// permSwaps returns swaps of tile positions that turn arrangement before
+// into after. Only positions that differ are visited.
+func permSwaps(before, after []int) [][2]int {
+	cur := make(map[int]int) // position -> tile, for changed positions
+	at := make(map[int]int)  // tile -> position
+	for i := range after {
+		if before[i] != after[i] {
+			cur[i] = before[i]
+			at[before[i]] = i
+		}
+	}
+	var swaps [][2]int
+	for i := range after {
+		if before[i] == after[i] {
+			continue
+		}
+		for cur[i] != after[i] {
+			j := at[after[i]]
+			swaps = append(swaps, [2]int{i, j})
+			cur[i], cur[j] = cur[j], cur[i]
+			at[cur[i]], at[cur[j]] = i, j
+		}
+	}
+	return swaps
+}
@@ -0,0 +1,189 @@
+package pixl
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"math"
+)
+
+// A Mask picks the parts of an image to pixelate. Only tiles with at least
+// one pixel in the mask are drawn; the rest of the image shows through.
+// The mask stays with positions in the grid, so tiles shuffled out of it
+// are hidden and tiles shuffled into it are shown.
+type Mask interface {
+	Covers(x, y int) bool
+}
+
+// AlphaMask covers the pixels of Image that aren't fully transparent.
+type AlphaMask struct {
+	Image image.Image
+}
+
+func (m AlphaMask) Covers(x, y int) bool {
+	b := m.Image.Bounds()
+	pt := image.Pt(x+b.Min.X, y+b.Min.Y)
+	if !pt.In(b) {
+		return false
+	}
+	_, _, _, a := m.Image.At(pt.X, pt.Y).RGBA()
+	return a > 0
+}
+
+// Regions covers a list of rectangles and polygons, the polygons filled by
+// the even-odd rule.
+type Regions struct {
+	Rects    []image.Rectangle
+	Polygons [][]Vertex
+}
+
+func (rs *Regions) Covers(x, y int) bool {
+	pt := image.Pt(x, y)
+	for _, r := range rs.Rects {
+		if pt.In(r) {
+			return true
+		}
+	}
+	for _, poly := range rs.Polygons {
+		if inPolygon(poly, float64(x)+0.5, float64(y)+0.5) {
+			return true
+		}
+	}
+	return false
+}
+
+// ReadRegions reads a JSON list of regions, each either a rectangle as
+// {"rect": [x, y, w, h]} or a polygon as {"polygon": [[x, y], ...]}.
+func ReadRegions(r io.Reader) (*Regions, error) {
+	var list []struct {
+		Rect    []int       `json:"rect"`
+		Polygon [][]float64 `json:"polygon"`
+	}
+	if err := json.NewDecoder(r).Decode(&list); err != nil {
+		return nil, fmt.Errorf("pixl: reading regions: %v", err)
+	}
+	rs := new(Regions)
+	for i, reg := range list {
+		switch {
+		case reg.Rect != nil && reg.Polygon == nil:
+			if len(reg.Rect) != 4 || reg.Rect[2] < 0 || reg.Rect[3] < 0 {
+				return nil, fmt.Errorf("pixl: region %d: rect must be [x, y, w, h]", i)
+			}
+			x, y := reg.Rect[0], reg.Rect[1]
+			rs.Rects = append(rs.Rects, image.Rect(x, y, x+reg.Rect[2], y+reg.Rect[3]))
+		case reg.Polygon != nil && reg.Rect == nil:
+			if len(reg.Polygon) < 3 {
+				return nil, fmt.Errorf("pixl: region %d: polygon needs at least 3 points", i)
+			}
+			poly := make([]Vertex, len(reg.Polygon))
+			for j, v := range reg.Polygon {
+				if len(v) != 2 {
+					return nil, fmt.Errorf("pixl: region %d: polygon points must be [x, y]", i)
+				}
+				poly[j] = Vertex{v[0], v[1]}
+			}
+			rs.Polygons = append(rs.Polygons, poly)
+		default:
+			return nil, fmt.Errorf("pixl: region %d: want one of rect or polygon", i)
+		}
+	}
+	return rs, nil
+}
+
+// inPolygon tests whether (x, y) is inside poly by counting the edges a ray
+// to the right crosses.
+func inPolygon(poly []Vertex, x, y float64) bool {
+	in := false
+	for i, j := 0, len(poly)-1; i < len(poly); j, i = i, i+1 {
+		a, b := poly[i], poly[j]
+		if (a.Y > y) != (b.Y > y) && x < a.X+(y-a.Y)*(b.X-a.X)/(b.Y-a.Y) {
+			in = !in
+		}
+	}
+	return in
+}
+
+// maskWeights works out which tiles m touches and how much of each pixel
+// comes from the tiles rather than the source: all of it in those tiles,
+// fading to none over feather pixels toward the edge of the area they
+// cover, and none elsewhere. Pixels m covers always come all from the
+// tiles, so the feather never lets through what the mask hides.
+func (g *Grid) maskWeights(m Mask, feather int) ([]bool, *image.Alpha) {
+	b := g.Bounds()
+	active := make([]bool, g.NumCols*g.NumRows)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if bl := g.TileAt(x, y); g.inBounds(bl) && m.Covers(x, y) {
+				active[g.index(bl)] = true
+			}
+		}
+	}
+	w, h := b.Dx(), b.Dy()
+	inside := func(x, y int) bool {
+		bl := g.TileAt(b.Min.X+x, b.Min.Y+y)
+		return g.inBounds(bl) && active[g.index(bl)]
+	}
+
+	// chamfer distance to the nearest pixel outside, in thirds of a pixel;
+	// the edges of the image don't count as outside
+	const straight, diagonal = 3, 4
+	dist := make([]int, w*h)
+	far := 3 * (w + h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if inside(x, y) {
+				dist[y*w+x] = far
+			}
+		}
+	}
+	relax := func(x, y, dx, dy, cost int) {
+		nx, ny := x+dx, y+dy
+		if nx >= 0 && ny >= 0 && nx < w && ny < h && dist[ny*w+nx]+cost < dist[y*w+x] {
+			dist[y*w+x] = dist[ny*w+nx] + cost
+		}
+	}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			relax(x, y, -1, 0, straight)
+			relax(x, y, 0, -1, straight)
+			relax(x, y, -1, -1, diagonal)
+			relax(x, y, 1, -1, diagonal)
+		}
+	}
+	for y := h - 1; y >= 0; y-- {
+		for x := w - 1; x >= 0; x-- {
+			relax(x, y, 1, 0, straight)
+			relax(x, y, 0, 1, straight)
+			relax(x, y, 1, 1, diagonal)
+			relax(x, y, -1, 1, diagonal)
+		}
+	}
+
+	weights := image.NewAlpha(b)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			d := dist[y*w+x]
+			wt := 0.0
+			switch {
+			case d == 0:
+			case feather <= 0 || d >= far || m.Covers(b.Min.X+x, b.Min.Y+y):
+				wt = 1
+			default:
+				wt = math.Min(1, float64(d)/(straight*float64(feather)))
+			}
+			weights.SetAlpha(b.Min.X+x, b.Min.Y+y, color.Alpha{uint8(math.Round(wt * 0xff))})
+		}
+	}
+	return active, weights
+}
+
+// unmask blends the rendered tiles over the source image by Weights.
+func (p *Pixl) unmask(tiles *image.RGBA) *image.RGBA {
+	img := image.NewRGBA(tiles.Bounds())
+	draw.Draw(img, img.Bounds(), p.source(p.Image), img.Bounds().Min, draw.Src)
+	draw.DrawMask(img, img.Bounds(), tiles, img.Bounds().Min, p.Weights, img.Bounds().Min, draw.Over)
+	return img
+}
@@ -0,0 +1,114 @@
+package mosaic
+
+import (
+	"encoding/json"
+	"image"
+	"image/color"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"pixl"
+
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+)
+
+// Entry is one library image and its average color.
+type Entry struct {
+	Path    string     `json:"path"`
+	Size    int64      `json:"size"`
+	ModTime int64      `json:"mtime"`
+	Avg     color.RGBA `json:"avg"`
+}
+
+// Index is the library of images a mosaic is built from. It is saved as
+// JSON so that only new or changed images need decoding next time.
+type Index struct {
+	Dir     string  `json:"dir"`
+	Entries []Entry `json:"entries"`
+}
+
+// image extensions indexed by BuildIndex
+var imageExts = map[string]bool{".png": true, ".jpg": true, ".jpeg": true, ".gif": true}
+
+// BuildIndex indexes every image under dir. Entries of old whose file has
+// the same size and modification time are reused as they are. Files that
+// can't be decoded are skipped and returned in skipped.
+func BuildIndex(dir string, old *Index) (idx *Index, skipped []string, err error) {
+	known := make(map[string]Entry)
+	if old != nil {
+		for _, e := range old.Entries {
+			known[e.Path] = e
+		}
+	}
+	idx = &Index{Dir: dir}
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !imageExts[strings.ToLower(filepath.Ext(path))] {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if e, ok := known[path]; ok && e.Size == fi.Size() && e.ModTime == fi.ModTime().UnixNano() {
+			idx.Entries = append(idx.Entries, e)
+			return nil
+		}
+		img, err := decodeFile(path)
+		if err != nil {
+			skipped = append(skipped, path)
+			return nil
+		}
+		avg := color.RGBAModel.Convert(pixl.Mean(img, img.Bounds())).(color.RGBA)
+		idx.Entries = append(idx.Entries, Entry{path, fi.Size(), fi.ModTime().UnixNano(), avg})
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	sort.Slice(idx.Entries, func(i, j int) bool { return idx.Entries[i].Path < idx.Entries[j].Path })
+	return idx, skipped, nil
+}
+
+// LoadIndex reads an index written by Save.
+func LoadIndex(name string) (*Index, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	idx := new(Index)
+	if err := json.NewDecoder(f).Decode(idx); err != nil {
+		return nil, err
+	}
+	return idx, nil
+}
+
+func (idx *Index) Save(name string) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(f).Encode(idx); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func decodeFile(name string) (image.Image, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	return img, err
+}
@@ -0,0 +1,266 @@
+// Package mosaic turns a pixelated image into a photomosaic by drawing each
+// tile as the library image whose average color matches it best.
+package mosaic
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"sort"
+	"sync"
+
+	"pixl"
+)
+
+// Options tunes how library images are picked and drawn.
+type Options struct {
+	// How many tiles may use the same library image; zero means no limit
+	MaxReuse int
+	// How far each library image is tinted toward its tile's color, from
+	// 0 (not at all) to 1 (flat tile)
+	Blend float64
+	// Color distance used for matching; squared RGB distance when nil
+	Dist func(c1, c2 color.Color) float64
+}
+
+// Mosaic paints the tiles of a pixl.Pixl with library images. Use its Paint
+// method as pixl.Pixl.Paint.
+type Mosaic struct {
+	idx  *Index
+	opts Options
+
+	mu sync.Mutex
+	// library images scaled to block sizes, by entry and size
+	scaled map[scaledKey]*image.RGBA
+	// why library images couldn't be loaded, by entry
+	failed map[int]error
+	// the tile colors in their order right after Pixelate, and the entry
+	// assigned to each; shuffling only moves tiles about, so this stays
+	// good until the colors themselves change
+	tiles  []color.RGBA
+	assign []int
+	// first error met loading a library image
+	Err error
+}
+
+type scaledKey struct {
+	entry int
+	size  image.Point
+}
+
+func New(idx *Index, opts Options) *Mosaic {
+	return &Mosaic{idx: idx, opts: opts, scaled: make(map[scaledKey]*image.RGBA), failed: make(map[int]error)}
+}
+
+// Paint draws every tile of p into dst as its best-matching library image,
+// cut to the tile's shape. Tiles whose image can't be loaded are drawn flat;
+// see Err.
+func (m *Mosaic) Paint(dst *image.RGBA, p *pixl.Pixl) {
+	assign := m.assigned(p)
+	for i, c := range p.Tiles {
+		bl := p.GetPoint(i)
+		r := p.GetBlock(bl)
+		mask := p.TileMask(bl)
+		if assign[i] < 0 {
+			draw.DrawMask(dst, r, &image.Uniform{c}, image.ZP, mask, r.Min, draw.Src)
+			continue
+		}
+		piece, err := m.piece(assign[i], r.Size())
+		if err != nil {
+			m.mu.Lock()
+			if m.Err == nil {
+				m.Err = err
+			}
+			m.mu.Unlock()
+			draw.DrawMask(dst, r, &image.Uniform{c}, image.ZP, mask, r.Min, draw.Src)
+			continue
+		}
+		if mask == nil {
+			draw.Draw(dst, r, piece, image.ZP, draw.Src)
+			if m.opts.Blend > 0 {
+				blend(dst, r, c, m.opts.Blend)
+			}
+			continue
+		}
+		// tint a copy, as the scaled piece is shared between tiles
+		tile := image.NewRGBA(r)
+		draw.Draw(tile, r, piece, image.ZP, draw.Src)
+		if m.opts.Blend > 0 {
+			blend(tile, r, c, m.opts.Blend)
+		}
+		draw.DrawMask(dst, r, tile, r.Min, mask, r.Min, draw.Over)
+	}
+}
+
+// Assign picks a library entry for each tile color, or -1 when the library
+// is exhausted. Tiles with the closest matches pick first, so that with
+// MaxReuse the scarce images go where they fit best.
+func (m *Mosaic) Assign(tiles []color.RGBA) []int {
+	entries := m.idx.Entries
+	assign := make([]int, len(tiles))
+	if len(entries) == 0 {
+		for i := range assign {
+			assign[i] = -1
+		}
+		return assign
+	}
+
+	// rank the library for each distinct color only once
+	ranked := make(map[color.RGBA][]int)
+	best := make([]float64, len(tiles))
+	for i, c := range tiles {
+		r, ok := ranked[c]
+		if !ok {
+			r = m.rank(c)
+			ranked[c] = r
+		}
+		best[i] = m.dist(c, entries[r[0]].Avg)
+	}
+	order := make([]int, len(tiles))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return best[order[a]] < best[order[b]] })
+
+	used := make([]int, len(entries))
+	for _, i := range order {
+		assign[i] = -1
+		for _, e := range ranked[tiles[i]] {
+			if m.opts.MaxReuse <= 0 || used[e] < m.opts.MaxReuse {
+				assign[i] = e
+				used[e]++
+				break
+			}
+		}
+	}
+	return assign
+}
+
+// assigned is Assign for the tiles of p, worked out for the tiles as they
+// were before shuffling and moved along with them, so that it's only redone
+// when the colors change.
+func (m *Mosaic) assigned(p *pixl.Pixl) []int {
+	// undo the shuffle, if there's a valid permutation to undo
+	orig := p.Tiles
+	if len(p.Perm) == len(p.Tiles) {
+		orig = make([]color.RGBA, len(p.Tiles))
+		for i, c := range p.Tiles {
+			orig[p.Perm[i]] = c
+		}
+	}
+	m.mu.Lock()
+	if !equalColors(orig, m.tiles) {
+		m.tiles, m.assign = orig, m.Assign(orig)
+	}
+	base := m.assign
+	m.mu.Unlock()
+	if len(p.Perm) != len(p.Tiles) {
+		return base
+	}
+	assign := make([]int, len(base))
+	for i, j := range p.Perm {
+		assign[i] = base[j]
+	}
+	return assign
+}
+
+func equalColors(a, b []color.RGBA) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// rank orders the library entries by distance to c.
+func (m *Mosaic) rank(c color.RGBA) []int {
+	entries := m.idx.Entries
+	r := make([]int, len(entries))
+	d := make([]float64, len(entries))
+	for i := range r {
+		r[i] = i
+		d[i] = m.dist(c, entries[i].Avg)
+	}
+	sort.SliceStable(r, func(a, b int) bool { return d[r[a]] < d[r[b]] })
+	return r
+}
+
+func (m *Mosaic) dist(c1, c2 color.RGBA) float64 {
+	if m.opts.Dist != nil {
+		return m.opts.Dist(c1, c2)
+	}
+	dr := float64(c1.R) - float64(c2.R)
+	dg := float64(c1.G) - float64(c2.G)
+	db := float64(c1.B) - float64(c2.B)
+	return dr*dr + dg*dg + db*db
+}
+
+// piece returns library entry e scaled to size, loading it on first use.
+// An entry that fails to load isn't tried again.
+func (m *Mosaic) piece(e int, size image.Point) (*image.RGBA, error) {
+	key := scaledKey{e, size}
+	m.mu.Lock()
+	img, ok := m.scaled[key]
+	err := m.failed[e]
+	m.mu.Unlock()
+	if ok || err != nil {
+		return img, err
+	}
+	src, err := decodeFile(m.idx.Entries[e].Path)
+	if err != nil {
+		m.mu.Lock()
+		m.failed[e] = err
+		m.mu.Unlock()
+		return nil, err
+	}
+	img = scale(src, size)
+	m.mu.Lock()
+	m.scaled[key] = img
+	m.mu.Unlock()
+	return img, nil
+}
+
+// scale crops src to the aspect ratio of size around its center and
+// resizes it by averaging the source pixels behind each output pixel.
+func scale(src image.Image, size image.Point) *image.RGBA {
+	b := src.Bounds()
+	// largest centered crop with the target aspect ratio
+	cw, ch := b.Dx(), b.Dy()
+	if cw*size.Y > ch*size.X {
+		cw = ch * size.X / size.Y
+	} else {
+		ch = cw * size.Y / size.X
+	}
+	crop := image.Rect(0, 0, max(cw, 1), max(ch, 1)).Add(b.Min).Add(image.Pt((b.Dx()-cw)/2, (b.Dy()-ch)/2))
+
+	dst := image.NewRGBA(image.Rect(0, 0, size.X, size.Y))
+	for y := 0; y < size.Y; y++ {
+		y0 := crop.Min.Y + y*crop.Dy()/size.Y
+		y1 := max(crop.Min.Y+(y+1)*crop.Dy()/size.Y, y0+1)
+		for x := 0; x < size.X; x++ {
+			x0 := crop.Min.X + x*crop.Dx()/size.X
+			x1 := max(crop.Min.X+(x+1)*crop.Dx()/size.X, x0+1)
+			dst.Set(x, y, pixl.Mean(src, image.Rect(x0, y0, x1, y1)))
+		}
+	}
+	return dst
+}
+
+// blend moves every pixel of dst within r a fraction t of the way to c.
+func blend(dst *image.RGBA, r image.Rectangle, c color.RGBA, t float64) {
+	t = math.Min(1, t)
+	mix := func(a, b uint8) uint8 {
+		return uint8(math.Round(float64(a)*(1-t) + float64(b)*t))
+	}
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			o := dst.RGBAAt(x, y)
+			dst.SetRGBA(x, y, color.RGBA{mix(o.R, c.R), mix(o.G, c.G), mix(o.B, c.B), mix(o.A, c.A)})
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package pixl
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/png"
+	"io"
+	"math/rand"
+)
+
+// PalettePixl is a Pixeler that keeps each tile as an index into a fixed
+// palette instead of as a block of RGBA pixels. Swaps only exchange indices,
+// so they are exact and cheap.
+type PalettePixl struct {
+	Grid
+	// Colors available to tiles, at most 256; defaults to the Plan 9 palette.
+	Palette color.Palette
+	// Decoded input image
+	Source image.Image
+	// Palette index of each tile, row by row
+	Tiles []uint8
+	// Source of randomness for shuffling; see Seed
+	Rand *rand.Rand
+}
+
+// Seed makes all later random choices derive from seed.
+func (p *PalettePixl) Seed(seed int64) {
+	p.Rand = newRand(seed)
+}
+
+func (p *PalettePixl) rng() *rand.Rand {
+	if p.Rand == nil {
+		p.Rand = newRand(DefaultSeed)
+	}
+	return p.Rand
+}
+
+func (p *PalettePixl) Decode(r io.Reader) error {
+	img, _, err := image.Decode(r)
+	if err == nil {
+		p.Source = img
+	}
+	return err
+}
+
+func (p *PalettePixl) Encode(w io.Writer) error {
+	return png.Encode(w, p.Render())
+}
+
+func (p *PalettePixl) Pixelate(s Spec, f AggregateFunc) error {
+	if p.Source == nil {
+		return ErrNoImage
+	}
+	if len(p.Palette) == 0 {
+		p.Palette = palette.Plan9
+	}
+	if len(p.Palette) > 256 {
+		return fmt.Errorf("pixl: palette has %d colors, PalettePixl holds at most 256", len(p.Palette))
+	}
+	p.layoutImage(p.Source, s, p.rng())
+	p.Tiles = make([]uint8, p.NumCols*p.NumRows)
+	p.aggregate(p.Source, f, p.FillBlock)
+	return nil
+}
+
+func (p *PalettePixl) Swap(p1, p2 image.Point) error {
+	i, j := p.index(p1), p.index(p2)
+	p.Tiles[i], p.Tiles[j] = p.Tiles[j], p.Tiles[i]
+	return nil
+}
+
+func (p *PalettePixl) Shuffle(f BiasFunc) error {
+	return shuffle(p, f, p.rng())
+}
+
+func (p *PalettePixl) ColorAt(bl image.Point) color.Color {
+	return p.Palette[p.Tiles[p.index(bl)]]
+}
+
+func (p *PalettePixl) FillBlock(bl image.Point, c color.Color) {
+	p.Tiles[p.index(bl)] = uint8(p.Palette.Index(c))
+}
+
+// Render draws the tiles into a paletted image.
+func (p *PalettePixl) Render() *image.Paletted {
+	img := image.NewPaletted(p.Bounds(), p.Palette)
+	p.render(img, func(i int) color.Color { return p.Palette[p.Tiles[i]] })
+	return img
+}
@@ -0,0 +1,116 @@
+package pixl
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"math/rand"
+	"sync"
+)
+
+// RegionSize is the side, in tiles, of the square regions ParallelStep
+// divides the grid into. Regions of the same phase must be at least two
+// tiles apart so that no swap in one reads a tile written by another.
+const RegionSize = 8
+
+// ParallelStep is a concurrent DoStep. The grid is cut into square regions
+// colored like a checkerboard with four phases; the regions of one phase
+// never touch, so their swaps are made by up to workers goroutines at once.
+// The regions are offset at random on each call so that over several calls
+// tiles can travel anywhere.
+// Each region draws from its own random source, seeded in order from the
+// Pixl's, so the outcome depends only on the seed and not on workers or
+// scheduling. dist must be safe for concurrent use; DistCache is.
+//
+// Neighboring Cells can be anywhere in the grid, so for those ParallelStep
+// is just DoStep.
+func (p *Pixl) ParallelStep(frequency float64, dist func(color.Color, color.Color) float64, workers int) int {
+	if p.irregular() {
+		return p.DoStep(frequency, dist)
+	}
+	if workers < 1 {
+		workers = 1
+	}
+	rnd := p.rng()
+
+	// move the regions about on every call, so tiles can cross their edges
+	ox, oy := rnd.Intn(RegionSize), rnd.Intn(RegionSize)
+	var phases [4][]region
+	for y := -oy; y < p.NumRows; y += RegionSize {
+		for x := -ox; x < p.NumCols; x += RegionSize {
+			r := image.Rect(x, y, x+RegionSize, y+RegionSize).Intersect(image.Rect(0, 0, p.NumCols, p.NumRows))
+			if r.Empty() {
+				continue
+			}
+			phase := ((x+ox)/RegionSize)%2 + 2*(((y+oy)/RegionSize)%2)
+			phases[phase] = append(phases[phase], region{r, rnd.Int63()})
+		}
+	}
+
+	moved := 0
+	for _, regions := range phases {
+		jobs := make(chan int)
+		counts := make([]int, len(regions))
+		var wg sync.WaitGroup
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := range jobs {
+					counts[i] = p.stepRegion(regions[i], frequency, dist)
+				}
+			}()
+		}
+		for i := range regions {
+			jobs <- i
+		}
+		close(jobs)
+		wg.Wait()
+		for _, n := range counts {
+			moved += n
+		}
+	}
+	return moved
+}
+
+type region struct {
+	r    image.Rectangle
+	seed int64
+}
+
+// stepRegion is DoStep restricted to moves within one region.
+func (p *Pixl) stepRegion(reg region, frequency float64, dist func(color.Color, color.Color) float64) int {
+	rnd := rand.New(rand.NewSource(reg.seed))
+	w, h := reg.r.Dx(), reg.r.Dy()
+	n := 0
+	for y := reg.r.Min.Y; y < reg.r.Max.Y; y++ {
+		for x := reg.r.Min.X; x < reg.r.Max.X; x++ {
+			if p.drawn(image.Pt(x, y)) {
+				n++
+			}
+		}
+	}
+	iters := int(math.Floor(float64(n) * frequency))
+	moved := 0
+	for i := 0; i < iters; i++ {
+		pt := reg.r.Min.Add(image.Pt(rnd.Intn(w), rnd.Intn(h)))
+		for !p.drawn(pt) {
+			pt = reg.r.Min.Add(image.Pt(rnd.Intn(w), rnd.Intn(h)))
+		}
+		minScore := 0.0
+		minPt := pt
+		for _, newPt := range p.Neighbors(pt) {
+			if newPt.In(reg.r) {
+				if score := SwapDelta(p, pt, newPt, dist); score < minScore {
+					minScore = score
+					minPt = newPt
+				}
+			}
+		}
+		if minPt != pt {
+			p.Swap(pt, minPt)
+			moved++
+		}
+	}
+	return moved
+}
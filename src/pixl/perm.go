@@ -0,0 +1,121 @@
+package pixl
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+)
+
+// PermFile is the exported form of a tile permutation.
+type PermFile struct {
+	Cols int   `json:"cols"`
+	Rows int   `json:"rows"`
+	Perm []int `json:"perm"`
+}
+
+// WritePerm exports p.Perm as JSON.
+func (p *Pixl) WritePerm(w io.Writer) error {
+	return json.NewEncoder(w).Encode(PermFile{p.NumCols, p.NumRows, p.Perm})
+}
+
+// ReadPerm reads a permutation written by WritePerm and checks it fits the
+// current grid.
+func (p *Pixl) ReadPerm(r io.Reader) ([]int, error) {
+	var pf PermFile
+	if err := json.NewDecoder(r).Decode(&pf); err != nil {
+		return nil, err
+	}
+	if pf.Cols != p.NumCols || pf.Rows != p.NumRows {
+		return nil, fmt.Errorf("pixl: permutation is for a %dx%d grid, not %dx%d", pf.Cols, pf.Rows, p.NumCols, p.NumRows)
+	}
+	if !isPerm(pf.Perm, len(p.Tiles)) {
+		return nil, fmt.Errorf("pixl: invalid permutation")
+	}
+	return pf.Perm, nil
+}
+
+// Unpermute moves the tile at i to perm[i] for every i, undoing the
+// rearrangement that perm records. Given an image saved after a shuffle
+// and the permutation exported with it, this restores the original, as long
+// as the grid is laid out the same for both; SLIC cells, which depend on the
+// image, aren't.
+func (p *Pixl) Unpermute(perm []int) error {
+	if !isPerm(perm, len(p.Tiles)) {
+		return fmt.Errorf("pixl: invalid permutation")
+	}
+	target := append([]int(nil), perm...)
+	// follow each cycle, swapping tiles straight into place
+	for i := range target {
+		for target[i] != i {
+			j := target[i]
+			p.Swap(p.GetPoint(i), p.GetPoint(j))
+			target[i], target[j] = target[j], target[i]
+		}
+	}
+	return nil
+}
+
+// Unshuffle puts every tile back where Pixelate left it.
+func (p *Pixl) Unshuffle() error {
+	return p.Unpermute(p.Perm)
+}
+
+// Inverse returns the permutation that undoes perm.
+func Inverse(perm []int) []int {
+	inv := make([]int, len(perm))
+	for i, j := range perm {
+		inv[j] = i
+	}
+	return inv
+}
+
+// Displacement summarizes how far tiles are from where they started, in
+// tiles.
+type Displacement struct {
+	Moved int     `json:"moved"`
+	Mean  float64 `json:"mean"`
+	Max   float64 `json:"max"`
+}
+
+func (p *Pixl) Displacement() Displacement {
+	var d Displacement
+	if len(p.Perm) == 0 {
+		return d
+	}
+	sum := 0.0
+	for i, j := range p.Perm {
+		if i == j {
+			continue
+		}
+		v := p.GetPoint(i).Sub(p.GetPoint(j))
+		dist := math.Hypot(float64(v.X), float64(v.Y))
+		d.Moved++
+		sum += dist
+		d.Max = math.Max(d.Max, dist)
+	}
+	d.Mean = sum / float64(len(allPoints(p)))
+	return d
+}
+
+func identity(n int) []int {
+	perm := make([]int, n)
+	for i := range perm {
+		perm[i] = i
+	}
+	return perm
+}
+
+func isPerm(perm []int, n int) bool {
+	if len(perm) != n {
+		return false
+	}
+	seen := make([]bool, n)
+	for _, j := range perm {
+		if j < 0 || j >= n || seen[j] {
+			return false
+		}
+		seen[j] = true
+	}
+	return true
+}
@@ -1,256 +1,261 @@
 package pixl
 
 import (
+	"errors"
 	"io"
 	// "fmt"
-	// "sort"
 	"image"
 	"image/draw"
-	"image/png"
 	"image/color"
 	"math"
 	"math/rand"
 
-	"x-go-binding/ui"
-
 	_ "image/jpeg"
 )
 
+// AggregateFunc computes a single representative color for the pixels of
+// img that fall inside r.
+type AggregateFunc func(img image.Image, r image.Rectangle) color.Color
+
+// BiasFunc decides whether the tiles at p1 and p2 may be swapped during a
+// shuffle.
+type BiasFunc func(p Pixeler, p1, p2 image.Point) bool
+
+var ErrNoImage = errors.New("pixl: no image decoded")
+
+// DefaultSeed is used when no random source has been set.
+const DefaultSeed = 1
+
+func newRand(seed int64) *rand.Rand {
+	return rand.New(rand.NewSource(seed))
+}
+
 type Pixeler interface {
 
 	Decode(r io.Reader) error
 
 	Encode(w io.Writer) error
 
-	// Down-sample image based on desired resolution and aggregate function
-	Pixelate(nb int, f func (bl image.Point, p *Pixeler) color.Color) error
+	// Down-sample image into the grid described by s using aggregate function f
+	Pixelate(s Spec, f AggregateFunc) error
 
 	// swap two tiles
 	Swap(p1, p2 image.Point) error
 
 	// Rearrange tiles, using f to bias the shuffle
-	Shuffle(f func(p1, p2 image.Point) bool) error
+	Shuffle(f BiasFunc) error
+
+	// Gets the color of a specific block
+	ColorAt(bl image.Point) color.Color
+
+	FillBlock(bl image.Point, c color.Color)
 
 	// Gets the bounding box for a specific block
 	GetBlock(bl image.Point) image.Rectangle
 
-	FillBlock(bl image.Point, c color.Color)
+	// Gets the blocks adjacent to bl
+	Neighbors(bl image.Point) []image.Point
+
+	// Gets the block with index bn, counting row by row
+	GetPoint(bn int) image.Point
+
+	// Number of columns and rows of blocks
+	Dims() (cols, rows int)
 
 }
 
+// compile-time checks
+var _ Pixeler = (*Pixl)(nil)
+var _ Pixeler = (*PalettePixl)(nil)
+
 type Pixl struct {
+	// Decoded input image
 	Image *image.RGBA
-	NumCols int
-	NumRows int
-	BlockSize int
-	Window ui.Window
+	Grid
+	// Color of each tile, row by row; set by Pixelate
+	Tiles []color.RGBA
+	// Perm[i] is the index the tile now at i had right after Pixelate
+	Perm []int
+	// Source of randomness for shuffling and clustering; see Seed
+	Rand *rand.Rand
+	// Extra text written into encoded PNGs, e.g. the seed of the run
+	Meta map[string]string
+	// Paint, if set, draws the tiles in Render instead of flat blocks of
+	// color, e.g. as photomosaic pieces
+	Paint func(dst *image.RGBA, p *Pixl)
+	// How much of each pixel Render takes from the tiles rather than the
+	// source, when pixelating under a mask; nil means all of it
+	Weights *image.Alpha
+	// which tiles the mask touches, by index
+	active []bool
+}
+
+// Seed makes all later random choices derive from seed.
+func (p *Pixl) Seed(seed int64) {
+	p.Rand = newRand(seed)
+}
+
+func (p *Pixl) rng() *rand.Rand {
+	if p.Rand == nil {
+		p.Rand = newRand(DefaultSeed)
+	}
+	return p.Rand
 }
 
 func (p *Pixl) Decode(r io.Reader) error {
 	img, _, err := image.Decode(r)
 	if err == nil {
-		// convert to RGBA image
-		newImg := image.NewRGBA(img.Bounds())
-		draw.Draw(newImg, newImg.Bounds(), img, image.ZP, draw.Src)
+		// convert to RGBA image anchored at the origin
+		b := img.Bounds()
+		newImg := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+		draw.Draw(newImg, newImg.Bounds(), img, b.Min, draw.Src)
 		p.Image = newImg
+		p.Tiles = nil
+		p.Perm = nil
 	}
 	return err
 }
 
 func (p *Pixl) Encode(w io.Writer) error {
-	return png.Encode(w, p.Image)
+	return encodePNG(w, p.Render(), p.Meta)
 }
 
-func (p *Pixl) Init(nb int) {
-	bounds := p.Image.Bounds()
-	width  := bounds.Dx()
-	height := bounds.Dy()
+// Render draws the tile grid into a new image. Before Pixelate has been
+// called it returns the decoded image unchanged.
+func (p *Pixl) Render() *image.RGBA {
+	if p.Tiles == nil {
+		return p.Image
+	}
+	img := image.NewRGBA(p.Bounds())
+	if p.Paint != nil {
+		p.Paint(img, p)
+	} else {
+		p.render(img, func(i int) color.Color { return p.Tiles[i] })
+	}
+	if p.Weights != nil {
+		return p.unmask(img)
+	}
+	return img
+}
 
-	p.NumCols = nb
-	p.BlockSize = width / nb
-	p.NumRows = height / p.BlockSize
+func (p *Pixl) Init(s Spec) {
+	p.layoutImage(p.Image, s, p.rng())
 }
 
-func (p *Pixl) Pixelate(nb int, f func (bl image.Point, p *Pixl) color.Color) error {
-	p.Init(nb)
-	var x, y int
-	// columns
-	for x=0; x < p.NumCols; x++ {
-		// rows
-		for y=0; y < p.NumRows; y++ {
-			pt := image.Pt(x,y)
-			p.FillBlock(pt, f(pt, p))
-		}
+func (p *Pixl) Pixelate(s Spec, f AggregateFunc) error {
+	if p.Image == nil {
+		return ErrNoImage
+	}
+	p.Init(s)
+	p.Tiles = make([]color.RGBA, p.NumCols*p.NumRows)
+	p.Perm = identity(len(p.Tiles))
+	p.aggregate(p.Image, f, p.FillBlock)
+	p.active, p.Weights = nil, nil
+	if s.Mask != nil {
+		p.active, p.Weights = p.maskWeights(s.Mask, s.Feather)
 	}
-	p.Crop()
 	return nil
 }
 
-// TODO: fixme
-func (p *Pixl) Crop() {
-	subImg := p.Image.SubImage(image.Rect(0,0, p.NumCols * p.BlockSize, p.NumRows * p.BlockSize))
-	newImg := image.NewRGBA(subImg.Bounds())
-	draw.Draw(newImg, newImg.Bounds(), subImg, image.ZP, draw.Src)
-	p.Image = newImg
-}
-
 func (p *Pixl) ColorAt(bl image.Point) color.Color {
-	return p.random(bl)
+	return p.Tiles[p.index(bl)]
 }
 
 func (p *Pixl) Swap(p1, p2 image.Point) error {
-	c1 := p.ColorAt(p1)
-	c2 := p.ColorAt(p2)
-	p.FillBlock(p2, c1)
-	p.FillBlock(p1, c2)
+	i, j := p.index(p1), p.index(p2)
+	p.Tiles[i], p.Tiles[j] = p.Tiles[j], p.Tiles[i]
+	p.Perm[i], p.Perm[j] = p.Perm[j], p.Perm[i]
 	return nil
 }
 
-// fisher-yates-ish shuffle
-func (p *Pixl) Shuffle(f func(p *Pixl, p1, p2 image.Point) bool) error {
-	for i:= p.NumCols * p.NumRows - 1; i > 0; i-- {
-		p1 := p.GetPoint(i)
-		p2 := p.GetPoint(rand.Int() % (i + 1))
+func (p *Pixl) Shuffle(f BiasFunc) error {
+	return shuffle(p, f, p.rng())
+}
+
+// fisher-yates-ish shuffle over any Pixeler
+func shuffle(p Pixeler, f BiasFunc, rnd *rand.Rand) error {
+	pts := allPoints(p)
+	for i:= len(pts) - 1; i > 0; i-- {
+		p1 := pts[i]
+		p2 := pts[rnd.Intn(i + 1)]
 		if f(p, p1, p2) {
-			p.Swap(p1, p2)
+			if err := p.Swap(p1, p2); err != nil {
+				return err
+			}
 		}
 	}
 	return nil
 }
 
-func (p *Pixl) inBounds(pt image.Point) bool {
-	return pt.X >= 0 && pt.X < p.NumCols && pt.Y >= 0 && pt.Y < p.NumRows
-}
-
-func (p *Pixl) DoStep(frequency float64, dist func (color.Color, color.Color) float64) {
+// DoStep moves a fraction of the tiles next to the neighbor that lowers the
+// energy the most and returns how many tiles actually moved. Each candidate
+// is scored with SwapDelta, so wrapping dist in a DistCache makes repeated
+// steps cheap.
+func (p *Pixl) DoStep(frequency float64, dist func (color.Color, color.Color) float64) int {
 
-	numCells := p.NumCols * p.NumRows
+	pts := allPoints(p)
+	numCells := len(pts)
 
 	iters := int(math.Floor(float64(numCells) * frequency))
+	rnd := p.rng()
+	moved := 0
 
 	for i:=0; i < iters; i++ {
-		bn := rand.Int() % numCells
-
-		pt := p.GetPoint(bn)
-
-		var minScore, currScore float64
-
-		minScore = math.MaxFloat64
-		minPt := image.Pt(-1,-1)  //invalid location
-
-		// look at all locations that are one away from the current point
-
-		for xDelta := -1; xDelta < 2; xDelta++ {
-			for yDelta:= -1; yDelta < 2; yDelta++ {
-				currScore = 0
-				delta := image.Pt(xDelta, yDelta)
-				newPt := pt.Add(delta)
-				if p.inBounds(newPt) {
-					// all nearest neighbors
-					for xd2 := -1; xd2 < 2; xd2++ {
-						for yd2 := -1; yd2 < 2; yd2++ {
-							delta := image.Pt(xd2, yd2)
-							neighborToMover := newPt.Add(delta)
-							neighborToMoved := pt.Add(delta)
-							if p.inBounds(neighborToMover) {
-								currScore += dist(p.ColorAt(pt), p.ColorAt(neighborToMover))
-							}
-							if p.inBounds(neighborToMoved) {
-								currScore += dist(p.ColorAt(newPt), p.ColorAt(neighborToMoved))
-							}
-						}
-					}
-					if currScore < minScore {
-						minScore = currScore
-						minPt = newPt
-					}
-				}
+		bn := rnd.Intn(numCells)
+
+		pt := pts[bn]
+
+		// staying put costs nothing
+		minScore := 0.0
+		minPt := pt
+
+		// look at all the tiles next to the current one
+		for _, newPt := range p.Neighbors(pt) {
+			if currScore := SwapDelta(p, pt, newPt, dist); currScore < minScore {
+				minScore = currScore
+				minPt = newPt
 			}
 		}
-		if minPt.X >= 0 && minPt.Y >= 0 {
+		if minPt != pt {
 			p.Swap(pt, minPt)
+			moved++
 		}
 	}
+	return moved
 }
 
 
-func (p *Pixl) GetPoint(bn int) image.Point {
-	x := (bn % p.NumCols)
-	y := (bn / p.NumCols)
-	return image.Pt(x, y)
-}
-
-func (p *Pixl) GetBlock(bl image.Point) image.Rectangle {
-	bs := p.BlockSize
-	return image.Rect(bl.X * bs, bl.Y * bs, (bl.X + 1)*bs, (bl.Y + 1)*bs)
-}
-
 func (p *Pixl) FillBlock(bl image.Point, c color.Color) {
-	draw.Draw(p.Image, p.GetBlock(bl), &image.Uniform{c}, image.ZP, draw.Src)
+	p.Tiles[p.index(bl)] = color.RGBAModel.Convert(c).(color.RGBA)
 }
 
-// func (p *Pixl) SortRows() {
-// 	for i:=0; i<p.NumRows; i++ {
-// 		sp := new(SubPixl)
-// 		sp.Init(p, i*p.NumCols, (i+1)*p.NumCols)
-// 		sp.Sort()
-// 	}
-// }
-
-func (p *Pixl) WriteToScreen() {
-	draw.Draw(p.Window.Screen(), p.Window.Screen().Bounds(), p.Image, image.ZP, draw.Src)
-	p.Window.FlushImage()
-}
-
-// TODO: replace with function that bins colors and selects the mode.
-func (p *Pixl) random (bl image.Point) color.Color {
-	subImg  := p.Image.SubImage(p.GetBlock(bl))
-	bounds  := subImg.Bounds()
-	offsetX := rand.Int() % p.BlockSize
-	offsetY := rand.Int() % p.BlockSize
-	return p.Image.At(bounds.Min.X + offsetX, bounds.Min.Y + offsetY)
+// Random returns an aggregate function that samples a single pixel from the
+// block using rnd.
+func Random(rnd *rand.Rand) AggregateFunc {
+	return func(img image.Image, r image.Rectangle) color.Color {
+		if _, ok := img.(Shaped); ok {
+			// count the pixels the tile covers and pick one
+			n := 0
+			pixels(img, r, func(x, y int) { n++ })
+			if n == 0 {
+				return color.Transparent
+			}
+			k := rnd.Intn(n)
+			var c color.Color
+			pixels(img, r, func(x, y int) {
+				if k == 0 {
+					c = img.At(x, y)
+				}
+				k--
+			})
+			return c
+		}
+		r = r.Intersect(img.Bounds())
+		if r.Empty() {
+			return color.Transparent
+		}
+		offsetX := rnd.Intn(r.Dx())
+		offsetY := rnd.Intn(r.Dy())
+		return img.At(r.Min.X + offsetX, r.Min.Y + offsetY)
+	}
 }
-
-
-// // represents a consecutive run of pixels
-// type SubPixl struct {
-// 	p *Pixl
-// 	Start int
-// 	End int
-// }
-
-// func (sp *SubPixl) Init(p *Pixl, s, e int) {
-// 	sp.p = p
-// 	sp.Start = s
-// 	sp.End = e
-// }
-
-// func (sp *SubPixl) Len() int {
-// 	return (sp.End + 1) - sp.Start
-// }
-
-// func (sp *SubPixl) GetXY(i int) (x, y int) {
-// 	x, y = sp.p.GetXY(sp.Start + i)
-// 	return x,y
-// }
-
-// func (sp *SubPixl) Color(i int) color.Color {
-// 	return sp.p.ColorAt(sp.GetXY(i))
-// }
-
-// func (sp *SubPixl) Swap(i, j int) {
-// 	x1, y1 := sp.GetXY(i)
-// 	x2, y2 := sp.GetXY(j)
-// 	sp.p.Swap(x1, y1, x2, y2)
-// }
-
-// func (sp *SubPixl) Less(i, j int) bool {
-// 	r1, g1, b1, _ := sp.Color(i).RGBA()
-// 	r2, g2, b2, _ := sp.Color(j).RGBA()
-// 	// currently sorts on blueness
-// 	return ((r1 < r2 || b1 < b2 && false) || g1 < g2) // TODO: less arbitrary criterion
-// }
-
-// func (sp *SubPixl) Sort() {
-// 	sort.Sort(sp)
-// }
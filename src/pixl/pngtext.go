@@ -0,0 +1,74 @@
+package pixl
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"io"
+	"sort"
+)
+
+// length of the PNG signature plus the IHDR chunk, which must come first
+const pngHeaderLen = 8 + 4 + 4 + 13 + 4
+
+// encodePNG writes img as a PNG with one text chunk per entry of meta, in
+// key order, placed right after the header. tEXt only holds Latin-1, so
+// values that aren't plain ASCII go in UTF-8 iTXt chunks instead.
+func encodePNG(w io.Writer, img image.Image, meta map[string]string) error {
+	if len(meta) == 0 {
+		return png.Encode(w, img)
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return err
+	}
+	data := buf.Bytes()
+	if _, err := w.Write(data[:pngHeaderLen]); err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(meta))
+	for k := range meta {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		typ, data := "tEXt", k+"\x00"+meta[k]
+		if !isASCII(meta[k]) {
+			// uncompressed, with empty language tag and translated keyword
+			typ, data = "iTXt", k+"\x00\x00\x00\x00\x00"+meta[k]
+		}
+		if err := writeChunk(w, typ, []byte(data)); err != nil {
+			return err
+		}
+	}
+	_, err := w.Write(data[pngHeaderLen:])
+	return err
+}
+
+func writeChunk(w io.Writer, typ string, data []byte) error {
+	var hdr [8]byte
+	binary.BigEndian.PutUint32(hdr[:4], uint32(len(data)))
+	copy(hdr[4:], typ)
+	crc := crc32.NewIEEE()
+	crc.Write(hdr[4:])
+	crc.Write(data)
+	var sum [4]byte
+	binary.BigEndian.PutUint32(sum[:], crc.Sum32())
+	for _, b := range [][]byte{hdr[:], data, sum[:]} {
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,101 @@
+package quant
+
+import (
+	"bufio"
+	"fmt"
+	"image/color"
+	"io"
+	"os"
+	"strings"
+)
+
+// Palettes maps the names accepted by Lookup to fixed palettes.
+var Palettes = map[string]color.Palette{
+	"pico8": hexPalette(
+		"000000", "1d2b53", "7e2553", "008751", "ab5236", "5f574f", "c2c3c7", "fff1e8",
+		"ff004d", "ffa300", "ffec27", "00e436", "29adff", "83769c", "ff77a8", "ffccaa",
+	),
+	"gameboy": hexPalette("0f380f", "306230", "8bac0f", "9bbc0f"),
+	"cga": hexPalette(
+		"000000", "0000aa", "00aa00", "00aaaa", "aa0000", "aa00aa", "aa5500", "aaaaaa",
+		"555555", "5555ff", "55ff55", "55ffff", "ff5555", "ff55ff", "ffff55", "ffffff",
+	),
+}
+
+// Lookup returns the named palette, or else reads name as a palette file.
+func Lookup(name string) (color.Palette, error) {
+	if p, ok := Palettes[name]; ok {
+		return p, nil
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("quant: %q is neither a known palette nor a readable file", name)
+	}
+	defer f.Close()
+	return Read(f)
+}
+
+// Read parses a GIMP palette (.gpl) or a list of hex colors, one per line,
+// with or without a leading '#'. Blank lines and lines starting with '#'
+// are ignored, other than hex colors.
+func Read(r io.Reader) (color.Palette, error) {
+	var pal color.Palette
+	sc := bufio.NewScanner(r)
+	line := 0
+	for sc.Scan() {
+		line++
+		s := strings.TrimSpace(sc.Text())
+		switch {
+		case s == "", strings.HasPrefix(s, "#") && !isHexColor(s[1:]):
+			continue
+		case line == 1 && s == "GIMP Palette":
+			continue
+		case strings.HasPrefix(s, "Name:"), strings.HasPrefix(s, "Columns:"):
+			continue
+		}
+		var c color.RGBA
+		if n, _ := fmt.Sscanf(s, "%d %d %d", &c.R, &c.G, &c.B); n == 3 {
+			// GIMP palette entry: R G B name
+			c.A = 0xff
+		} else if h := strings.TrimPrefix(s, "#"); len(h) == 6 {
+			if _, err := fmt.Sscanf(h, "%02x%02x%02x", &c.R, &c.G, &c.B); err != nil {
+				return nil, fmt.Errorf("quant: line %d: bad color %q", line, s)
+			}
+			c.A = 0xff
+		} else {
+			return nil, fmt.Errorf("quant: line %d: bad color %q", line, s)
+		}
+		pal = append(pal, c)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(pal) == 0 {
+		return nil, fmt.Errorf("quant: empty palette")
+	}
+	return pal, nil
+}
+
+// isHexColor reports whether s is six hex digits.
+func isHexColor(s string) bool {
+	if len(s) != 6 {
+		return false
+	}
+	for _, r := range s {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", r) {
+			return false
+		}
+	}
+	return true
+}
+
+func hexPalette(hex ...string) color.Palette {
+	pal := make(color.Palette, len(hex))
+	for i, h := range hex {
+		var c color.RGBA
+		fmt.Sscanf(h, "%02x%02x%02x", &c.R, &c.G, &c.B)
+		c.A = 0xff
+		pal[i] = c
+	}
+	return pal
+}
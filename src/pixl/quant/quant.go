@@ -0,0 +1,162 @@
+// Package quant reduces a set of colors to a small palette, either by
+// choosing representative colors or by using a fixed retro palette.
+package quant
+
+import (
+	"image/color"
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// MedianCut picks up to n colors representative of cs by repeatedly
+// splitting the box of colors with the widest channel at its median.
+func MedianCut(cs []color.RGBA, n int) color.Palette {
+	if len(cs) == 0 {
+		return color.Palette{color.Black}
+	}
+	boxes := [][]color.RGBA{append([]color.RGBA(nil), cs...)}
+	for len(boxes) < n {
+		// split the box with the largest range on any channel
+		best, bestCh, bestRange := -1, 0, 0
+		for i, b := range boxes {
+			if len(b) < 2 {
+				continue
+			}
+			if ch, rng := widestChannel(b); rng > bestRange {
+				best, bestCh, bestRange = i, ch, rng
+			}
+		}
+		if best < 0 {
+			break
+		}
+		b := boxes[best]
+		sort.Slice(b, func(i, j int) bool { return channel(b[i], bestCh) < channel(b[j], bestCh) })
+		mid := len(b) / 2
+		boxes[best] = b[:mid]
+		boxes = append(boxes, b[mid:])
+	}
+	pal := make(color.Palette, len(boxes))
+	for i, b := range boxes {
+		pal[i] = mean(b)
+	}
+	return pal
+}
+
+// KMeans picks n colors by k-means clustering in RGB space, seeded with
+// k-means++ so that the result depends only on rnd.
+func KMeans(cs []color.RGBA, n int, rnd *rand.Rand) color.Palette {
+	const maxIters = 50
+	if len(cs) == 0 {
+		return color.Palette{color.Black}
+	}
+	if n > len(cs) {
+		n = len(cs)
+	}
+
+	// k-means++: each new center is chosen with probability proportional
+	// to its squared distance from the nearest existing one
+	centers := []color.RGBA{cs[rnd.Intn(len(cs))]}
+	d2 := make([]float64, len(cs))
+	for len(centers) < n {
+		sum := 0.0
+		for i, c := range cs {
+			d2[i] = math.Inf(1)
+			for _, ctr := range centers {
+				d2[i] = math.Min(d2[i], sqDist(c, ctr))
+			}
+			sum += d2[i]
+		}
+		if sum == 0 {
+			break
+		}
+		t := rnd.Float64() * sum
+		i := 0
+		for ; i < len(cs)-1 && t >= d2[i]; i++ {
+			t -= d2[i]
+		}
+		centers = append(centers, cs[i])
+	}
+
+	assign := make([]int, len(cs))
+	for it := 0; it < maxIters; it++ {
+		changed := it == 0
+		for i, c := range cs {
+			if k := nearest(c, centers); k != assign[i] {
+				assign[i] = k
+				changed = true
+			}
+		}
+		if !changed {
+			break
+		}
+		groups := make([][]color.RGBA, len(centers))
+		for i, c := range cs {
+			groups[assign[i]] = append(groups[assign[i]], c)
+		}
+		for k, g := range groups {
+			if len(g) > 0 {
+				centers[k] = mean(g)
+			}
+		}
+	}
+	pal := make(color.Palette, len(centers))
+	for i, c := range centers {
+		pal[i] = c
+	}
+	return pal
+}
+
+func nearest(c color.RGBA, centers []color.RGBA) int {
+	best, bestD := 0, math.Inf(1)
+	for k, ctr := range centers {
+		if d := sqDist(c, ctr); d < bestD {
+			best, bestD = k, d
+		}
+	}
+	return best
+}
+
+func sqDist(a, b color.RGBA) float64 {
+	dr := float64(a.R) - float64(b.R)
+	dg := float64(a.G) - float64(b.G)
+	db := float64(a.B) - float64(b.B)
+	return dr*dr + dg*dg + db*db
+}
+
+func mean(cs []color.RGBA) color.RGBA {
+	var r, g, b, a int
+	for _, c := range cs {
+		r += int(c.R)
+		g += int(c.G)
+		b += int(c.B)
+		a += int(c.A)
+	}
+	k := len(cs)
+	return color.RGBA{uint8(r / k), uint8(g / k), uint8(b / k), uint8(a / k)}
+}
+
+func widestChannel(cs []color.RGBA) (ch, rng int) {
+	for c := 0; c < 3; c++ {
+		lo, hi := 255, 0
+		for _, col := range cs {
+			v := channel(col, c)
+			lo = min(lo, v)
+			hi = max(hi, v)
+		}
+		if hi-lo > rng {
+			ch, rng = c, hi-lo
+		}
+	}
+	return ch, rng
+}
+
+func channel(c color.RGBA, ch int) int {
+	switch ch {
+	case 0:
+		return int(c.R)
+	case 1:
+		return int(c.G)
+	}
+	return int(c.B)
+}
@@ -0,0 +1,20 @@
+package pixl
+
+import (
+	"image/color"
+)
+
+// Quantize replaces the color of every tile with the closest color of pal
+// under dist.
+func (p *Pixl) Quantize(pal color.Palette, dist func(c1, c2 color.Color) float64) {
+	// tiles share colors, so look each one up only once
+	nearest := make(map[color.RGBA]color.RGBA)
+	for i, c := range p.Tiles {
+		q, ok := nearest[c]
+		if !ok {
+			q = nearestColor(pal, c, dist)
+			nearest[c] = q
+		}
+		p.Tiles[i] = q
+	}
+}
@@ -0,0 +1,182 @@
+package pixl
+
+import (
+	"fmt"
+	"image"
+	"math/rand"
+	"strings"
+)
+
+// ShuffleFunc rearranges the tiles of p using rnd. Unlike a BiasFunc, which
+// can only veto the swaps of a full shuffle, it chooses which tiles move and
+// where to.
+type ShuffleFunc func(p Pixeler, rnd *rand.Rand) error
+
+func (p *Pixl) ShuffleWith(s ShuffleFunc) error {
+	return s(p, p.rng())
+}
+
+func (p *PalettePixl) ShuffleWith(s ShuffleFunc) error {
+	return s(p, p.rng())
+}
+
+// Unbiased shuffles all tiles uniformly.
+func Unbiased() ShuffleFunc {
+	return func(p Pixeler, rnd *rand.Rand) error {
+		return shuffleAmong(p, allPoints(p), rnd)
+	}
+}
+
+// Local shuffles the tiles within windows r+1 tiles on a side, so no tile
+// ends up more than r tiles away from where it was in either direction and
+// the image is scrambled but keeps its overall layout. The windows are
+// offset at random so their edges don't fall in the same place every time.
+func Local(r int) ShuffleFunc {
+	return func(p Pixeler, rnd *rand.Rand) error {
+		cols, rows := p.Dims()
+		side := r + 1
+		ox, oy := rnd.Intn(side), rnd.Intn(side)
+		grid := image.Rect(0, 0, cols, rows)
+		for y := -oy; y < rows; y += side {
+			for x := -ox; x < cols; x += side {
+				win := image.Rect(x, y, x+side, y+side).Intersect(grid)
+				var pts []image.Point
+				for py := win.Min.Y; py < win.Max.Y; py++ {
+					for px := win.Min.X; px < win.Max.X; px++ {
+						pts = append(pts, image.Pt(px, py))
+					}
+				}
+				if err := shuffleAmong(p, pts, rnd); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+}
+
+// WithinRows shuffles each row of tiles separately.
+func WithinRows() ShuffleFunc {
+	return func(p Pixeler, rnd *rand.Rand) error {
+		return shuffleLines(p, Rows(p.Dims()), rnd)
+	}
+}
+
+// WithinCols shuffles each column of tiles separately.
+func WithinCols() ShuffleFunc {
+	return func(p Pixeler, rnd *rand.Rand) error {
+		return shuffleLines(p, Columns(p.Dims()), rnd)
+	}
+}
+
+// LumaBand shuffles only the tiles whose luminance is within [lo, hi],
+// among themselves.
+func LumaBand(lo, hi float64) ShuffleFunc {
+	return func(p Pixeler, rnd *rand.Rand) error {
+		var pts []image.Point
+		for _, pt := range allPoints(p) {
+			if l := Luma(p.ColorAt(pt)); l >= lo && l <= hi {
+				pts = append(pts, pt)
+			}
+		}
+		return shuffleAmong(p, pts, rnd)
+	}
+}
+
+// Partial picks the given fraction of tiles at random and shuffles them
+// among themselves, leaving the rest in place.
+func Partial(frac float64) ShuffleFunc {
+	return func(p Pixeler, rnd *rand.Rand) error {
+		pts := allPoints(p)
+		k := int(frac * float64(len(pts)))
+		if k > len(pts) {
+			k = len(pts)
+		}
+		// choose k tiles with a partial fisher-yates over the list
+		for i := 0; i < k; i++ {
+			j := i + rnd.Intn(len(pts)-i)
+			pts[i], pts[j] = pts[j], pts[i]
+		}
+		return shuffleAmong(p, pts[:k], rnd)
+	}
+}
+
+// LookupShuffle parses a strategy of the form NAME or NAME:ARG. The
+// strategies are all, local:R, rows, cols, luma:LO-HI and partial:FRAC.
+func LookupShuffle(spec string) (ShuffleFunc, error) {
+	name, arg, _ := strings.Cut(spec, ":")
+	bad := fmt.Errorf("pixl: bad shuffle strategy %q", spec)
+	switch name {
+	case "all":
+		return Unbiased(), nil
+	case "rows":
+		return WithinRows(), nil
+	case "cols":
+		return WithinCols(), nil
+	case "local":
+		var r int
+		if _, err := fmt.Sscanf(arg, "%d", &r); err != nil || r < 1 {
+			return nil, bad
+		}
+		return Local(r), nil
+	case "luma":
+		var lo, hi float64
+		if _, err := fmt.Sscanf(arg, "%g-%g", &lo, &hi); err != nil || lo > hi {
+			return nil, bad
+		}
+		return LumaBand(lo, hi), nil
+	case "partial":
+		var frac float64
+		if _, err := fmt.Sscanf(arg, "%g", &frac); err != nil || frac < 0 || frac > 1 {
+			return nil, bad
+		}
+		return Partial(frac), nil
+	}
+	return nil, bad
+}
+
+func shuffleLines(p Pixeler, lines [][]image.Point, rnd *rand.Rand) error {
+	for _, line := range lines {
+		if err := shuffleAmong(p, line, rnd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// shuffleAmong is a fisher-yates shuffle of the tiles at pts, leaving out
+// blank tiles.
+func shuffleAmong(p Pixeler, pts []image.Point, rnd *rand.Rand) error {
+	pts = drawnPoints(p, pts)
+	for i := len(pts) - 1; i > 0; i-- {
+		j := rnd.Intn(i + 1)
+		if i == j {
+			continue
+		}
+		if err := p.Swap(pts[i], pts[j]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// allPoints lists the tiles of p that aren't blank, in index order.
+func allPoints(p Pixeler) []image.Point {
+	cols, rows := p.Dims()
+	pts := make([]image.Point, cols*rows)
+	for i := range pts {
+		pts[i] = p.GetPoint(i)
+	}
+	return drawnPoints(p, pts)
+}
+
+// drawnPoints filters out the blank tiles from pts, reusing its storage.
+func drawnPoints(p Pixeler, pts []image.Point) []image.Point {
+	out := pts[:0]
+	for _, pt := range pts {
+		if isDrawn(p, pt) {
+			out = append(out, pt)
+		}
+	}
+	return out
+}
@@ -0,0 +1,234 @@
+package pixl
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"sort"
+)
+
+// KeyFunc maps a color to the value tiles are sorted by, normally in [0, 1].
+type KeyFunc func(c color.Color) float64
+
+// Keys maps the names accepted by LookupKey to their functions.
+var Keys = map[string]KeyFunc{
+	"hue":        Hue,
+	"luma":       Luma,
+	"saturation": Saturation,
+	"red":        channel(0),
+	"green":      channel(1),
+	"blue":       channel(2),
+}
+
+func LookupKey(name string) (KeyFunc, error) {
+	k, ok := Keys[name]
+	if !ok {
+		return nil, fmt.Errorf("pixl: unknown sort key %q", name)
+	}
+	return k, nil
+}
+
+// Hue returns the HSV hue of c in [0, 1).
+func Hue(c color.Color) float64 {
+	r, g, b := unitRGB(c)
+	max := math.Max(r, math.Max(g, b))
+	min := math.Min(r, math.Min(g, b))
+	d := max - min
+	if d == 0 {
+		return 0
+	}
+	var h float64
+	switch max {
+	case r:
+		h = math.Mod((g-b)/d+6, 6)
+	case g:
+		h = (b-r)/d + 2
+	default:
+		h = (r-g)/d + 4
+	}
+	return h / 6
+}
+
+// Saturation returns the HSV saturation of c in [0, 1].
+func Saturation(c color.Color) float64 {
+	r, g, b := unitRGB(c)
+	max := math.Max(r, math.Max(g, b))
+	min := math.Min(r, math.Min(g, b))
+	if max == 0 {
+		return 0
+	}
+	return (max - min) / max
+}
+
+func channel(i int) KeyFunc {
+	return func(c color.Color) float64 {
+		r, g, b := unitRGB(c)
+		return [3]float64{r, g, b}[i]
+	}
+}
+
+func unitRGB(c color.Color) (r, g, b float64) {
+	cr, cg, cb, _ := c.RGBA()
+	return float64(cr) / 0xffff, float64(cg) / 0xffff, float64(cb) / 0xffff
+}
+
+// PathFunc lays out the tiles of a cols x rows grid as a set of lines along
+// which tiles are sorted.
+type PathFunc func(cols, rows int) [][]image.Point
+
+// Paths maps the names accepted by LookupPath to their functions.
+var Paths = map[string]PathFunc{
+	"rows":      Rows,
+	"cols":      Columns,
+	"diagonals": Diagonals,
+	"hilbert":   Hilbert,
+}
+
+func LookupPath(name string) (PathFunc, error) {
+	f, ok := Paths[name]
+	if !ok {
+		return nil, fmt.Errorf("pixl: unknown sort path %q", name)
+	}
+	return f, nil
+}
+
+// Rows yields each row, left to right.
+func Rows(cols, rows int) [][]image.Point {
+	lines := make([][]image.Point, rows)
+	for y := range lines {
+		for x := 0; x < cols; x++ {
+			lines[y] = append(lines[y], image.Pt(x, y))
+		}
+	}
+	return lines
+}
+
+// Columns yields each column, top to bottom.
+func Columns(cols, rows int) [][]image.Point {
+	lines := make([][]image.Point, cols)
+	for x := range lines {
+		for y := 0; y < rows; y++ {
+			lines[x] = append(lines[x], image.Pt(x, y))
+		}
+	}
+	return lines
+}
+
+// Diagonals yields each top-left to bottom-right diagonal.
+func Diagonals(cols, rows int) [][]image.Point {
+	var lines [][]image.Point
+	for d := -(rows - 1); d < cols; d++ {
+		var line []image.Point
+		for y := 0; y < rows; y++ {
+			if x := d + y; x >= 0 && x < cols {
+				line = append(line, image.Pt(x, y))
+			}
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// Hilbert yields a single line following a Hilbert curve over the grid, so
+// that tiles close together on the line are close together in the image.
+func Hilbert(cols, rows int) [][]image.Point {
+	n := 1
+	for n < cols || n < rows {
+		n *= 2
+	}
+	line := make([]image.Point, 0, cols*rows)
+	for d := 0; d < n*n; d++ {
+		if pt := hilbertPoint(n, d); pt.X < cols && pt.Y < rows {
+			line = append(line, pt)
+		}
+	}
+	return [][]image.Point{line}
+}
+
+// hilbertPoint converts a distance d along the curve filling an n x n square
+// into a point.
+func hilbertPoint(n, d int) image.Point {
+	x, y := 0, 0
+	for s := 1; s < n; s *= 2 {
+		rx := 1 & (d / 2)
+		ry := 1 & (d ^ rx)
+		if ry == 0 {
+			if rx == 1 {
+				x, y = s-1-x, s-1-y
+			}
+			x, y = y, x
+		}
+		x += s * rx
+		y += s * ry
+		d /= 4
+	}
+	return image.Pt(x, y)
+}
+
+// represents a consecutive run of tiles along a line
+type SubPixl struct {
+	p   Pixeler
+	Pts []image.Point
+	Key KeyFunc
+	// keys cached so each tile's key is computed once per sort
+	keys []float64
+}
+
+func NewSubPixl(p Pixeler, pts []image.Point, key KeyFunc) *SubPixl {
+	sp := &SubPixl{p: p, Pts: pts, Key: key, keys: make([]float64, len(pts))}
+	for i := range pts {
+		sp.keys[i] = key(sp.Color(i))
+	}
+	return sp
+}
+
+func (sp *SubPixl) Len() int {
+	return len(sp.Pts)
+}
+
+func (sp *SubPixl) Color(i int) color.Color {
+	return sp.p.ColorAt(sp.Pts[i])
+}
+
+func (sp *SubPixl) Swap(i, j int) {
+	sp.p.Swap(sp.Pts[i], sp.Pts[j])
+	sp.keys[i], sp.keys[j] = sp.keys[j], sp.keys[i]
+}
+
+func (sp *SubPixl) Less(i, j int) bool {
+	return sp.keys[i] < sp.keys[j]
+}
+
+func (sp *SubPixl) Sort() {
+	sort.Stable(sp)
+}
+
+// SortTiles is the classic pixel-sorting effect. Along each line, every
+// maximal run of tiles whose key lies within [lo, hi] is sorted by key;
+// tiles outside the range, and blank tiles, stay put and break the line
+// into intervals.
+func SortTiles(p Pixeler, lines [][]image.Point, key KeyFunc, lo, hi float64) {
+	for _, line := range lines {
+		start := -1
+		for i := 0; i <= len(line); i++ {
+			in := false
+			if i < len(line) && isDrawn(p, line[i]) {
+				k := key(p.ColorAt(line[i]))
+				in = k >= lo && k <= hi
+			}
+			switch {
+			case in && start < 0:
+				start = i
+			case !in && start >= 0:
+				NewSubPixl(p, line[start:i], key).Sort()
+				start = -1
+			}
+		}
+	}
+}
+
+// SortRows sorts every row of tiles by key.
+func (p *Pixl) SortRows(key KeyFunc) {
+	SortTiles(p, Rows(p.NumCols, p.NumRows), key, math.Inf(-1), math.Inf(1))
+}
@@ -0,0 +1,124 @@
+package pixl
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// WriteSVG writes the tile grid as an SVG image with one rect per run of
+// same-colored tiles along a row, or one polygon or path per tile when they
+// aren't square, so it can be scaled to any size without interpolation.
+// Under a mask, only the tiles it touches are written, over the source
+// embedded as a PNG, and the edges aren't feathered.
+func (p *Pixl) WriteSVG(w io.Writer) error {
+	b := p.Bounds()
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`+"\n",
+		b.Dx(), b.Dy(), b.Dx(), b.Dy())
+	if p.Weights != nil {
+		src := image.NewRGBA(b)
+		draw.Draw(src, b, p.source(p.Image), b.Min, draw.Src)
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, src); err != nil {
+			return err
+		}
+		fmt.Fprintf(bw, `<image width="%d" height="%d" href="data:image/png;base64,%s"/>`+"\n",
+			b.Dx(), b.Dy(), base64.StdEncoding.EncodeToString(buf.Bytes()))
+	}
+	// shown reports whether the tile at i is drawn
+	shown := func(i int) bool {
+		return p.drawn(p.GetPoint(i)) && (p.active == nil || p.active[i])
+	}
+	if !p.rectangular() {
+		var runs []strings.Builder
+		if p.Polygon(image.Pt(0, 0)) == nil {
+			runs = p.svgRuns()
+		}
+		for i, c := range p.Tiles {
+			if !shown(i) {
+				continue
+			}
+			if runs != nil {
+				fmt.Fprintf(bw, `<path d="%s" %s/>`+"\n", runs[i].String(), svgFill(c))
+				continue
+			}
+			fmt.Fprint(bw, `<polygon points="`)
+			for j, v := range p.Polygon(p.GetPoint(i)) {
+				if j > 0 {
+					fmt.Fprint(bw, " ")
+				}
+				fmt.Fprintf(bw, "%s,%s", svgNum(v.X), svgNum(v.Y))
+			}
+			fmt.Fprintf(bw, `" %s/>`+"\n", svgFill(c))
+		}
+		fmt.Fprintln(bw, "</svg>")
+		return bw.Flush()
+	}
+	for y := 0; y < p.NumRows; y++ {
+		for x := 0; x < p.NumCols; {
+			i := p.index(image.Pt(x, y))
+			if !shown(i) {
+				x++
+				continue
+			}
+			c := p.Tiles[i]
+			end := x + 1
+			for end < p.NumCols && shown(i+end-x) && p.Tiles[i+end-x] == c {
+				end++
+			}
+			r := p.GetBlock(image.Pt(x, y)).Union(p.GetBlock(image.Pt(end-1, y)))
+			fmt.Fprintf(bw, `<rect x="%d" y="%d" width="%d" height="%d" %s/>`+"\n",
+				r.Min.X, r.Min.Y, r.Dx(), r.Dy(), svgFill(c))
+			x = end
+		}
+	}
+	fmt.Fprintln(bw, "</svg>")
+	return bw.Flush()
+}
+
+// svgRuns outlines tiles that aren't polygons as one SVG path per tile,
+// made of a rectangle for every run of its pixels along a row.
+func (p *Pixl) svgRuns() []strings.Builder {
+	runs := make([]strings.Builder, len(p.Tiles))
+	b := p.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; {
+			bl := p.TileAt(x, y)
+			end := x + 1
+			for end < b.Max.X && p.TileAt(end, y) == bl {
+				end++
+			}
+			if p.inBounds(bl) {
+				fmt.Fprintf(&runs[p.index(bl)], "M%d %dh%dv1h%dz", x, y, end-x, x-end)
+			}
+			x = end
+		}
+	}
+	return runs
+}
+
+// svgFill formats c as SVG fill attributes, adding an opacity if it isn't
+// opaque.
+func svgFill(c color.RGBA) string {
+	n := color.NRGBAModel.Convert(c).(color.NRGBA)
+	fill := fmt.Sprintf(`fill="#%02x%02x%02x"`, n.R, n.G, n.B)
+	if n.A != 0xff {
+		fill += fmt.Sprintf(` fill-opacity="%.3f"`, float64(n.A)/0xff)
+	}
+	return fill
+}
+
+// svgNum formats a coordinate with no more digits than it needs.
+func svgNum(f float64) string {
+	return strconv.FormatFloat(math.Round(f*100)/100, 'f', -1, 64)
+}
@@ -0,0 +1,155 @@
+package pixl
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Topology is the shape and arrangement of the tiles of a Grid. Tiles are
+// always addressed by column and row, but where that puts them in the image
+// and which tiles touch is up to the topology.
+type Topology interface {
+	// Layout sets the number and size of tiles in g for an image of
+	// g.Width by g.Height pixels.
+	Layout(g *Grid, s Spec)
+	// Bounds is the size of the rendered grid.
+	Bounds(g *Grid) image.Rectangle
+	// TileAt returns the tile covering pixel (x, y); pixels past the edges
+	// of the grid give tiles outside it.
+	TileAt(g *Grid, x, y int) image.Point
+	// Block is the bounding box of tile bl.
+	Block(g *Grid, bl image.Point) image.Rectangle
+	// Neighbors lists the tiles adjacent to bl, which may lie outside the
+	// grid.
+	Neighbors(g *Grid, bl image.Point) []image.Point
+	// Polygon is the outline of tile bl in pixels, or nil if it isn't a
+	// polygon.
+	Polygon(g *Grid, bl image.Point) []Vertex
+}
+
+// Vertex is a corner of a tile outline.
+type Vertex struct {
+	X, Y float64
+}
+
+// Shaped is implemented by images that cover only some of the pixels in
+// their bounds, such as the part of an image under one hexagonal tile.
+// Aggregate functions skip the pixels for which In is false.
+type Shaped interface {
+	In(x, y int) bool
+}
+
+// Topologies maps the names accepted by LookupTopology to constructors.
+// The cell topologies take a parameter after a colon: the jitter for
+// "voronoi:J" and the compactness for "slic:M".
+var Topologies = map[string]func() Topology{
+	"square":    func() Topology { return Square{} },
+	"hex":       func() Topology { return HexOffset{} },
+	"hex-axial": func() Topology { return HexAxial{} },
+	"tri":       func() Topology { return Triangle{} },
+	"voronoi":   func() Topology { return &Voronoi{Jitter: 1} },
+	"slic":      func() Topology { return &SLIC{Compactness: 10} },
+}
+
+func LookupTopology(spec string) (Topology, error) {
+	name, arg, hasArg := strings.Cut(spec, ":")
+	if hasArg {
+		var v float64
+		if _, err := fmt.Sscanf(arg, "%g", &v); err != nil || v < 0 {
+			return nil, fmt.Errorf("pixl: bad tile shape %q", spec)
+		}
+		switch name {
+		case "voronoi":
+			if v > 1 {
+				return nil, fmt.Errorf("pixl: voronoi jitter must be between 0 and 1")
+			}
+			return &Voronoi{Jitter: v}, nil
+		case "slic":
+			return &SLIC{Compactness: v}, nil
+		}
+		return nil, fmt.Errorf("pixl: tile shape %q takes no parameter", name)
+	}
+	f, ok := Topologies[name]
+	if !ok {
+		var names []string
+		for n := range Topologies {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("pixl: unknown tile shape %q (want %s)", name, strings.Join(names, ", "))
+	}
+	return f(), nil
+}
+
+// tileSize works out the width and height of a tile from s. ratio is the
+// height of a regular tile over its width, used when only one dimension is
+// known. x and y say how the tiles are spaced along each axis, so that a
+// number of columns or rows in s gives exactly that many.
+func tileSize(g *Grid, s Spec, ratio float64, x, y spacing) (w, h int) {
+	switch {
+	case s.BlockW > 0:
+		w = s.BlockW
+	case s.Cols > 0:
+		w = x.fit(g.Width, s.Cols)
+	}
+	switch {
+	case s.BlockH > 0:
+		h = s.BlockH
+	case s.Rows > 0:
+		h = y.fit(g.Height, s.Rows)
+	}
+	if w < 1 && h > 0 {
+		w = int(math.Round(float64(h) / ratio))
+	}
+	if h < 1 {
+		h = int(math.Round(float64(w) * ratio))
+	}
+	if w < 2 {
+		w = 2
+	}
+	if h < 2 {
+		h = 2
+	}
+	return w, h
+}
+
+// spacing says where tiles fall along an axis, in tile lengths: tile i
+// starts at i*pitch-overhang.
+type spacing struct {
+	pitch, overhang float64
+}
+
+// fit is the shortest tile length for which the tile after the first n
+// starts at or past size, so that n tiles cover it, or as few as the
+// spacing allows if n is fewer.
+func (sp spacing) fit(size, n int) int {
+	n = max(n, int(sp.overhang/sp.pitch)+1)
+	return int(math.Ceil(float64(size) / (float64(n)*sp.pitch - sp.overhang)))
+}
+
+// count is the number of tiles along an axis that reach covers: n if s
+// asked for that many rather than for a size, else just reach. fit makes n
+// tiles enough to cover the image, but tile sizes are whole pixels, so they
+// may get there early and leave blank tiles after it.
+func count(reach, n, size int) int {
+	if n > 0 && size <= 0 {
+		return max(reach, n)
+	}
+	return reach
+}
+
+// extent counts the columns and rows needed to reach the furthest tiles at
+// puts the pixels of g in. Tiles only ever come later to the right and
+// further down, so just the right and bottom edges are looked at.
+func extent(g *Grid, at func(x, y int) image.Point) (cols, rows int) {
+	for y := 0; y < g.Height; y++ {
+		cols = max(cols, at(g.Width-1, y).X+1)
+	}
+	for x := 0; x < g.Width; x++ {
+		rows = max(rows, at(x, g.Height-1).Y+1)
+	}
+	return cols, rows
+}
@@ -0,0 +1,70 @@
+package pixl
+
+import (
+	"image"
+	"math"
+)
+
+// Triangle alternates upward and downward pointing triangles BlockW wide and
+// BlockH tall along each row, overlapping by half their width. Tile (x, y)
+// points up when x+y is even; tile (0, 0) is centered on the left edge of
+// the image, so tiles along the edges are clipped. Every tile touches the
+// three it shares an edge with.
+type Triangle struct{}
+
+func (t Triangle) Layout(g *Grid, s Spec) {
+	// each tile starts half a tile after the one before, and the first
+	// half a tile left of the image
+	g.BlockW, g.BlockH = tileSize(g, s, math.Sqrt(3)/2, spacing{0.5, 0.5}, spacing{1, 0})
+	cols, rows := extent(g, func(x, y int) image.Point { return t.TileAt(g, x, y) })
+	g.NumCols, g.NumRows = count(cols, s.Cols, s.BlockW), count(rows, s.Rows, s.BlockH)
+}
+
+func (Triangle) Bounds(g *Grid) image.Rectangle {
+	return image.Rect(0, 0, g.Width, g.Height)
+}
+
+func (Triangle) TileAt(g *Grid, x, y int) image.Point {
+	// in half tile widths from the left edge of tile 0
+	fx := (float64(x)+0.5)/(float64(g.BlockW)/2) + 1
+	fy := (float64(y) + 0.5) / float64(g.BlockH)
+	k, r := int(math.Floor(fx)), int(math.Floor(fy))
+	// the strip from k to k+1 is split by a diagonal between the left
+	// half of tile k and the right half of tile k-1
+	s, t := fx-float64(k), fy-float64(r)
+	if triangleUp(k, r) {
+		if s < 1-t {
+			k--
+		}
+	} else if s < t {
+		k--
+	}
+	return image.Pt(k, r)
+}
+
+func (Triangle) Block(g *Grid, bl image.Point) image.Rectangle {
+	w := float64(g.BlockW) / 2
+	return image.Rect(int(math.Floor(float64(bl.X-1)*w)), bl.Y*g.BlockH, int(math.Ceil(float64(bl.X+1)*w)), (bl.Y+1)*g.BlockH)
+}
+
+func (Triangle) Neighbors(g *Grid, bl image.Point) []image.Point {
+	ns := []image.Point{bl.Add(image.Pt(-1, 0)), bl.Add(image.Pt(1, 0))}
+	if triangleUp(bl.X, bl.Y) {
+		return append(ns, bl.Add(image.Pt(0, 1)))
+	}
+	return append(ns, bl.Add(image.Pt(0, -1)))
+}
+
+func (Triangle) Polygon(g *Grid, bl image.Point) []Vertex {
+	w := float64(g.BlockW) / 2
+	x0, x1, x2 := float64(bl.X-1)*w, float64(bl.X)*w, float64(bl.X+1)*w
+	top, bottom := float64(bl.Y*g.BlockH), float64((bl.Y+1)*g.BlockH)
+	if triangleUp(bl.X, bl.Y) {
+		return []Vertex{{x1, top}, {x2, bottom}, {x0, bottom}}
+	}
+	return []Vertex{{x0, top}, {x2, top}, {x1, bottom}}
+}
+
+func triangleUp(x, y int) bool {
+	return (x+y)&1 == 0
+}